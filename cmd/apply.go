@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/progress"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/worker"
+	"github.com/xanzy/go-gitlab"
+)
+
+func ApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Removes tags from a plan file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return applyPlan(cmd, args)
+		},
+	}
+
+	cmd.Flags().String("plan", "", "Path to plan file")
+	cmd.Flags().Bool("dry-run", false, "Specifies command should be ran in dry-run mode")
+	cmd.Flags().Bool("progress", false, "Outputs progress")
+	cmd.Flags().Int("concurrency", 1, "Maximum concurrent tag verifications and removals")
+	cmd.MarkFlagRequired("plan")
+
+	return cmd
+}
+
+func applyPlan(cmd *cobra.Command, args []string) error {
+	planPath, _ := cmd.Flags().GetString("plan")
+	p, err := plan.Read(planPath)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := newGitlabClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Applying plan %s created at %s with %d tags for removal", planPath, p.CreatedAt, len(p.Deletions))
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	progressFlag, _ := cmd.Flags().GetBool("progress")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+	var mu sync.Mutex
+	errors := false
+	skipped := 0
+	removed := 0
+	// wouldRemove is the amount of tags which would have been removed in dry-run mode
+	wouldRemove := 0
+
+	repositories := getPlanRepositories(cmd, client, p)
+
+	bar := progress.NewProgress(progressFlag, len(p.Deletions))
+	bar.Start()
+	// Failures are logged rather than returned, so that remaining deletions are still applied
+	worker.Run(concurrency, len(p.Deletions), func(i int) error {
+		defer bar.Increment()
+		deletion := p.Deletions[i]
+
+		changed, err := planDeletionChanged(deletion, repositories[planRepositoryKey{deletion.ProjectID, deletion.RepositoryID}])
+		if err != nil {
+			log.Errorf("Failed to verify tag %s in repository %s: %s", deletion.Tag, deletion.Repository, err)
+			mu.Lock()
+			errors = true
+			mu.Unlock()
+			return nil
+		}
+		if changed {
+			mu.Lock()
+			skipped++
+			mu.Unlock()
+			return nil
+		}
+
+		if dryRun {
+			log.Warnf("[DRY RUN]: Would remove tag %s from repository %s", deletion.Tag, deletion.Repository)
+			mu.Lock()
+			wouldRemove++
+			mu.Unlock()
+			return nil
+		}
+
+		log.Infof("Removing tag %s from repository %s", deletion.Tag, deletion.Repository)
+		_, err = client.DeleteRegistryRepositoryTag(deletion.ProjectID, deletion.RepositoryID, deletion.Tag)
+		if err != nil {
+			log.Errorf("Failed to remove tag %s from repository %s: %s", deletion.Tag, deletion.Repository, err)
+			mu.Lock()
+			errors = true
+			mu.Unlock()
+			return nil
+		}
+
+		mu.Lock()
+		removed++
+		mu.Unlock()
+		return nil
+	})
+	bar.Finish()
+
+	if dryRun {
+		log.Warnf("[DRY RUN]: Finished applying plan: %d tags would be removed, %d tags skipped", wouldRemove, skipped)
+	} else {
+		log.Infof("Finished applying plan: %d tags removed, %d tags skipped", removed, skipped)
+	}
+
+	if errors {
+		return fmt.Errorf("One or more errors occurred applying plan")
+	}
+
+	return nil
+}
+
+// planRepositoryKey identifies a repository within a plan
+type planRepositoryKey struct {
+	projectID    int
+	repositoryID int
+}
+
+// planRepository holds the current tags within a repository of a plan
+type planRepository struct {
+	tags []*gitlab.RegistryRepositoryTag
+	// planned holds digests of tags planned for removal from the repository, by tag name
+	planned map[string]string
+	// err is the error retrieving tags, where failed
+	err error
+}
+
+// getPlanRepositories retrieves current tags once for each repository within p
+func getPlanRepositories(cmd *cobra.Command, client api.Client, p *plan.Plan) map[planRepositoryKey]*planRepository {
+	repositories := make(map[planRepositoryKey]*planRepository)
+	for _, deletion := range p.Deletions {
+		key := planRepositoryKey{deletion.ProjectID, deletion.RepositoryID}
+		repository, exists := repositories[key]
+		if !exists {
+			log.Infof("Retrieving tags for repository %s", deletion.Repository)
+			repository = &planRepository{planned: make(map[string]string)}
+			repository.tags, repository.err = getRepositoryTags(cmd, client, &gitlab.RegistryRepository{ID: deletion.RepositoryID, Path: deletion.Repository}, deletion.ProjectID)
+			repositories[key] = repository
+		}
+		repository.planned[deletion.Tag] = deletion.Digest
+	}
+
+	return repositories
+}
+
+// planDeletionChanged returns true if the tag for deletion no longer exists, its digest has changed since
+// the plan was created, or its digest is now shared with a tag which isn't also being removed
+func planDeletionChanged(deletion plan.Deletion, repository *planRepository) (bool, error) {
+	if repository.err != nil {
+		return false, repository.err
+	}
+
+	var tag *gitlab.RegistryRepositoryTag
+	for _, t := range repository.tags {
+		if t.Name == deletion.Tag {
+			tag = t
+		}
+	}
+	if tag == nil {
+		log.Warnf("Skipping tag %s in repository %s as it no longer exists", deletion.Tag, deletion.Repository)
+		return true, nil
+	}
+
+	if tag.Digest != deletion.Digest {
+		log.Warnf("Skipping tag %s in repository %s as digest has changed from %s to %s", deletion.Tag, deletion.Repository, deletion.Digest, tag.Digest)
+		return true, nil
+	}
+
+	// Removing the tag would remove the manifest of other tags sharing its digest, unless they're also
+	// being removed with an unchanged digest
+	for _, t := range repository.tags {
+		if t.Name != deletion.Tag && t.Digest == deletion.Digest && repository.planned[t.Name] != t.Digest {
+			log.Warnf("Skipping tag %s in repository %s as digest %s is now shared with tag %s", deletion.Tag, deletion.Repository, deletion.Digest, t.Name)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
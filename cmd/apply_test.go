@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+	"github.com/xanzy/go-gitlab"
+)
+
+func TestApplyPlan(t *testing.T) {
+	t.Run("DigestChanged_SkipsTag", func(t *testing.T) {
+		registry := setupTest(t, testPolicies)
+
+		p := plan.NewPlan()
+		p.Add(
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "test1",
+				Digest:       "sha256:group10/project1test1",
+			},
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "test2",
+				Digest:       "sha256:changed",
+			},
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "missing",
+				Digest:       "sha256:missing",
+			},
+		)
+		path := filepath.Join(t.TempDir(), "plan.json")
+		err := p.Write(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := ApplyCmd()
+		cmd.Flags().Set("plan", path)
+		err = applyPlan(cmd, nil)
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 1)
+		assert.Equal(t, "test1", registry.Deleted[0].Tag)
+	})
+
+	t.Run("DryRun_DoesNotCountTagsRemoved", func(t *testing.T) {
+		registry := setupTest(t, testPolicies)
+
+		p := plan.NewPlan()
+		p.Add(
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "test1",
+				Digest:       "sha256:group10/project1test1",
+			},
+		)
+		path := filepath.Join(t.TempDir(), "plan.json")
+		err := p.Write(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hook := test.NewLocal(log.StandardLogger())
+		defer hook.Reset()
+
+		cmd := ApplyCmd()
+		cmd.Flags().Set("plan", path)
+		cmd.Flags().Set("dry-run", "true")
+		err = applyPlan(cmd, nil)
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+		assert.Equal(t, "[DRY RUN]: Finished applying plan: 1 tags would be removed, 0 tags skipped", hook.LastEntry().Message)
+	})
+
+	t.Run("TagRetaggedAfterPlan_SkipsTag", func(t *testing.T) {
+		registry := setupTest(t, testPolicies)
+
+		p := plan.NewPlan()
+		p.Add(
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "test1",
+				Digest:       "sha256:group10/project1test1",
+			},
+			plan.Deletion{
+				ProjectID:    1,
+				RepositoryID: 100,
+				Tag:          "test2",
+				Digest:       "sha256:group10/project1test2",
+			},
+		)
+		path := filepath.Join(t.TempDir(), "plan.json")
+		err := p.Write(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// stable is promoted to the manifest of test1 after the plan was created
+		createdAt := time.Now()
+		registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: "stable", Digest: "sha256:group10/project1test1", CreatedAt: &createdAt})
+
+		cmd := ApplyCmd()
+		cmd.Flags().Set("plan", path)
+		err = applyPlan(cmd, nil)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "stable", "test1", "test3", "test4", "test5"}, registry.TagNames(100))
+		assert.Equal(t, 1, registry.Requests["ListRegistryRepositoryTags"])
+	})
+}
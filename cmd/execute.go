@@ -2,13 +2,21 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
 	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
 	"github.com/ukfast/gitlab-registry-cleanup/pkg/filter"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/inuse"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
 	"github.com/ukfast/gitlab-registry-cleanup/pkg/progress"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/report"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/worker"
 	"github.com/xanzy/go-gitlab"
 )
 
@@ -24,33 +32,138 @@ func ExecuteCmd() *cobra.Command {
 	cmd.Flags().Bool("dry-run", false, "Specifies command should be ran in dry-run mode")
 	cmd.Flags().Bool("progress", false, "Outputs progress")
 	cmd.Flags().StringSlice("policy", []string{""}, "Limit policies to execute")
+	cmd.Flags().Int("concurrency", 1, "Maximum concurrent tag detail retrievals and removals per repository")
+	cmd.Flags().Bool("force", false, "Ignores deletion limits")
+	cmd.Flags().String("as-of", "", "Evaluates policies as of given RFC3339 timestamp or date, rather than now")
+	cmd.Flags().String("report", "", "Outputs a report of the run in given format (json)")
+	cmd.Flags().String("report-file", "", "Writes report to given file rather than stdout, implies --report json")
+	cmd.Flags().StringArray("in-use-file", []string{}, "File listing in-use images which are never removed, as JSON (e.g. kubectl get pods -o json) or plain text. Can be repeated")
 
 	return cmd
 }
 
 func executeCleanup(cmd *cobra.Command, args []string) error {
-	cfg := &config.Config{}
-	err := viper.Unmarshal(cfg)
+	reportFormat, _ := cmd.Flags().GetString("report")
+	reportFile, _ := cmd.Flags().GetString("report-file")
+	if len(reportFormat) > 0 && reportFormat != report.FormatJSON {
+		return fmt.Errorf("Unsupported report format %s", reportFormat)
+	}
+
+	run := &cleanupRun{report: report.NewReport()}
+	err := runCleanup(cmd, run)
+
+	if len(reportFormat) > 0 || len(reportFile) > 0 {
+		run.report.Finish()
+		reportErr := writeReport(run.report, reportFile)
+		if reportErr != nil {
+			log.Errorf("Failed to write report: %s", reportErr)
+			if err == nil {
+				err = reportErr
+			}
+		}
+	}
+
+	return err
+}
+
+// writeReport writes r to file at path, or stdout where path isn't specified
+func writeReport(r *report.Report, path string) error {
+	if len(path) > 0 {
+		log.Infof("Writing report to %s", path)
+		return r.WriteFile(path)
+	}
+
+	return r.Write(os.Stdout)
+}
+
+// cleanupRun holds state shared across all repository configs processed within a single run
+type cleanupRun struct {
+	// plan is populated with selected tags rather than tags being removed, where provided
+	plan *plan.Plan
+	// deletions is the amount of tags removed or planned for removal so far
+	deletions int
+	// skipped specifies whether any repositories were skipped due to exceeding deletion limits
+	skipped bool
+	// allProjects caches all projects, retrieved for repository configs without a project or group
+	allProjects []*gitlab.Project
+	// groupProjects caches projects by group, retrieved for repository configs with a group
+	groupProjects map[groupProjectsKey][]*gitlab.Project
+	// groupRepositories caches registry repositories by group ID, keyed by project ID
+	groupRepositories map[int]map[int][]*gitlab.RegistryRepository
+	// report records the outcome of the run for each repository config
+	report *report.Report
+	// asOf is the time policies are evaluated as of
+	asOf time.Time
+	// branches caches branch names by project ID, retrieved where required by policies
+	branches map[int][]string
+	// mergeRequests caches merge requests by project ID, retrieved where required by policies
+	mergeRequests map[int]*projectMergeRequests
+	// environments caches environments by project ID, retrieved where required by repository configs
+	environments map[int][]*gitlab.Environment
+	// gitTags caches Git tag names by project ID, retrieved where required by policies
+	gitTags map[int][]string
+	// protectedTags caches protected tag names by project ID, retrieved where required by policies
+	protectedTags map[int][]string
+	// projectFiles caches Git repository files by project ID, retrieved where required by repository configs
+	projectFiles map[int]*projectFiles
+	// extractors caches image reference extractors by registry host
+	extractors map[string]*inuse.Extractor
+	// inUse contains in-use images parsed from in-use files, which are never removed
+	inUse *inuse.Set
+}
+
+// groupProjectsKey identifies a group project listing, which differs by whether subgroups are included
+type groupProjectsKey struct {
+	groupID int
+	recurse bool
+}
+
+// runCleanup processes all repository configs
+func runCleanup(cmd *cobra.Command, run *cleanupRun) error {
+	if run.report == nil {
+		run.report = report.NewReport()
+	}
+	run.report.DryRun, _ = cmd.Flags().GetBool("dry-run")
+
+	asOf, err := getAsOf(cmd)
+	if err != nil {
+		run.report.AddError(err)
+		return err
+	}
+	run.asOf = asOf
+	run.report.AsOf = asOf
+
+	cfg, err := loadConfig()
+	if err != nil {
+		run.report.AddError(err)
+		return err
+	}
+
+	run.inUse, err = getInUse(cmd, cfg)
 	if err != nil {
-		return fmt.Errorf("Failed to unmarshal config: %w", err)
+		run.report.AddError(err)
+		return err
 	}
 
-	client, err := gitlab.NewClient(viper.GetString("access_token"), gitlab.WithBaseURL(viper.GetString("url")))
+	client, err := newGitlabClient(cfg)
 	if err != nil {
-		return fmt.Errorf("Failed initialising Gitlab client: %s", err)
+		run.report.AddError(err)
+		return err
 	}
 
-	log.Info("Retrieving all projects")
-	projects, err := getAllProjects(client)
+	err = resolveRepositoryTargets(client, cfg)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve projects: %s", err)
+		run.report.AddError(err)
+		return err
 	}
 
 	errors := false
 	for _, repositoryConfig := range cfg.Repositories {
-		err := processRepositoryConfig(cmd, client, projects, cfg, repositoryConfig)
+		configReport := run.report.AddRepositoryConfig(repositoryConfig.Project, repositoryConfig.Group)
+		err := processRepositoryConfig(cmd, client, cfg, repositoryConfig, run, configReport)
 		if err != nil {
-			log.Error("Failed to process repository: %s", err)
+			log.Errorf("Failed to process repository: %s", err)
+			configReport.Error = err.Error()
 			errors = true
 		}
 	}
@@ -59,20 +172,152 @@ func executeCleanup(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("One or more errors occurred processing repositories")
 	}
 
+	if run.skipped {
+		return fmt.Errorf("One or more repositories were skipped as deletion limits were exceeded")
+	}
+
 	return nil
 }
 
-func processRepositoryConfig(cmd *cobra.Command, client *gitlab.Client, projects []*gitlab.Project, cfg *config.Config, repositoryConfig config.RepositoryConfig) error {
+// getAsOf returns the time policies are evaluated as of, parsed from the as-of flag where specified. As
+// evaluating policies at another time would bypass age based protection, as-of is only accepted for commands
+// which don't remove tags, i.e. where the command has no dry-run flag (plan, explain) or dry-run is specified
+func getAsOf(cmd *cobra.Command) (time.Time, error) {
+	asOf, _ := cmd.Flags().GetString("as-of")
+	if len(asOf) == 0 {
+		return time.Now(), nil
+	}
+
+	if cmd.Flags().Lookup("dry-run") != nil {
+		if dryRun, _ := cmd.Flags().GetBool("dry-run"); !dryRun {
+			return time.Time{}, fmt.Errorf("as-of can only be specified with dry-run")
+		}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		t, err := time.Parse(layout, asOf)
+		if err == nil {
+			if t.After(time.Now()) {
+				return time.Time{}, fmt.Errorf("Invalid as-of %s, cannot be in the future", asOf)
+			}
+
+			log.Infof("Evaluating policies as of %s", t)
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("Invalid as-of %s, expected RFC3339 timestamp or date (YYYY-MM-DD)", asOf)
+}
+
+// tagsCreatedBy returns tags created at or before asOf, excluding tags which wouldn't have existed. Only
+// applied where as-of is specified, as tags pushed during a run would otherwise be excluded
+func tagsCreatedBy(tags []*gitlab.RegistryRepositoryTag, asOf time.Time) []*gitlab.RegistryRepositoryTag {
+	var createdTags []*gitlab.RegistryRepositoryTag
+	for _, tag := range tags {
+		if tag.CreatedAt != nil && tag.CreatedAt.After(asOf) {
+			log.Debugf("Ignoring tag %s created after %s", tag.Name, asOf)
+			continue
+		}
+		createdTags = append(createdTags, tag)
+	}
+
+	return createdTags
+}
+
+// resolveRepositoryTargets resolves project and group IDs for all repository configs, where
+// specified by either ID or full path. Each path is resolved once per run, regardless of the
+// amount of repository configs referencing it
+func resolveRepositoryTargets(client api.Client, cfg *config.Config) error {
+	projectIDs := make(map[string]int)
+	groupIDs := make(map[string]int)
+
+	errors := false
+	for i := range cfg.Repositories {
+		repositoryConfig := &cfg.Repositories[i]
+
+		if len(repositoryConfig.Project) > 0 {
+			id, resolved := projectIDs[repositoryConfig.Project]
+			if !resolved {
+				var err error
+				id, err = resolveProjectID(client, repositoryConfig.Project)
+				if err != nil {
+					log.Errorf("Failed to resolve project %s: %s", repositoryConfig.Project, err)
+					errors = true
+				}
+				projectIDs[repositoryConfig.Project] = id
+			}
+			repositoryConfig.ProjectID = id
+		}
+
+		if len(repositoryConfig.Group) > 0 {
+			id, resolved := groupIDs[repositoryConfig.Group]
+			if !resolved {
+				var err error
+				id, err = resolveGroupID(client, repositoryConfig.Group)
+				if err != nil {
+					log.Errorf("Failed to resolve group %s: %s", repositoryConfig.Group, err)
+					errors = true
+				}
+				groupIDs[repositoryConfig.Group] = id
+			}
+			repositoryConfig.GroupID = id
+		}
+	}
+
+	if errors {
+		return fmt.Errorf("One or more repository config projects or groups could not be resolved")
+	}
+
+	return nil
+}
+
+func resolveProjectID(client api.Client, project string) (int, error) {
+	if id, err := strconv.Atoi(project); err == nil {
+		return id, nil
+	}
+
+	p, resp, err := client.GetProject(project, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 0, fmt.Errorf("Project with path %s not found", project)
+		}
+		return 0, err
+	}
+
+	log.Infof("Resolved project %s to ID %d", project, p.ID)
+
+	return p.ID, nil
+}
+
+func resolveGroupID(client api.Client, group string) (int, error) {
+	if id, err := strconv.Atoi(group); err == nil {
+		return id, nil
+	}
+
+	g, resp, err := client.GetGroup(group)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 0, fmt.Errorf("Group with path %s not found", group)
+		}
+		return 0, err
+	}
+
+	log.Infof("Resolved group %s to ID %d", group, g.ID)
+
+	return g.ID, nil
+}
+
+func processRepositoryConfig(cmd *cobra.Command, client api.Client, cfg *config.Config, repositoryConfig config.RepositoryConfig, run *cleanupRun, configReport *report.RepositoryConfig) error {
 	log.WithFields(log.Fields{
 		"project": repositoryConfig.Project,
 		"group":   repositoryConfig.Group,
 	}).Info("Processing repository config")
-	projectIDs, err := getRepositoryProjects(cmd, client, projects, repositoryConfig)
+	projectIDs, err := getRepositoryProjects(client, repositoryConfig, run)
 	if err != nil {
 		return fmt.Errorf("Failed retrieving repository projects: %s", err)
 	}
 
-	err = processRepositoryProjects(cmd, client, cfg, repositoryConfig, projectIDs)
+	err = processRepositoryProjects(cmd, client, cfg, repositoryConfig, projectIDs, run, configReport)
 	if err != nil {
 		return fmt.Errorf("Failed to process repository config projects: %s", err)
 	}
@@ -85,36 +330,63 @@ func processRepositoryConfig(cmd *cobra.Command, client *gitlab.Client, projects
 	return nil
 }
 
-func getRepositoryProjects(cmd *cobra.Command, client *gitlab.Client, projects []*gitlab.Project, repositoryConfig config.RepositoryConfig) ([]int, error) {
-	var projectIDs []int
+// getRepositoryProjects retrieves IDs of projects targeted by repository config, excluding archived
+// projects and projects without container registry enabled
+func getRepositoryProjects(client api.Client, repositoryConfig config.RepositoryConfig, run *cleanupRun) ([]int, error) {
+	var projects []*gitlab.Project
 
-	for _, project := range projects {
-		// Skip if container registry not enabled
-		if !project.ContainerRegistryEnabled {
-			log.Tracef("Container registry not enabled for project %d", project.ID)
-			continue
+	switch {
+	case repositoryConfig.ProjectID > 0:
+		log.Debugf("Retrieving project %d", repositoryConfig.ProjectID)
+		project, _, err := client.GetProject(repositoryConfig.ProjectID, nil)
+		if err != nil {
+			return nil, err
 		}
+		projects = []*gitlab.Project{project}
+	case repositoryConfig.GroupID > 0:
+		// Group projects are only retrieved once per run for each group, regardless of amount of
+		// repository configs targeting the group
+		key := groupProjectsKey{groupID: repositoryConfig.GroupID, recurse: repositoryConfig.Recurse}
+		groupProjects, exists := run.groupProjects[key]
+		if !exists {
+			log.Debugf("Retrieving projects for group %d", repositoryConfig.GroupID)
+			var err error
+			groupProjects, err = getAllGroupProjects(client, repositoryConfig.GroupID, repositoryConfig.Recurse)
+			if err != nil {
+				return nil, err
+			}
 
-		// If project, check if match
-		if repositoryConfig.Project > 0 && repositoryConfig.Project != project.ID {
-			continue
+			if run.groupProjects == nil {
+				run.groupProjects = make(map[groupProjectsKey][]*gitlab.Project)
+			}
+			run.groupProjects[key] = groupProjects
 		}
-
-		// If group, check if match
-		if repositoryConfig.Group > 0 {
-			groupIDs := []int{project.Namespace.ID}
-
-			if repositoryConfig.Recurse {
-				parentGroupIDs, err := getParentGroupIDsRecursive(client, project.Namespace.ID)
-				if err != nil {
-					return nil, err
-				}
-				groupIDs = append(groupIDs, parentGroupIDs...)
+		projects = groupProjects
+	default:
+		// All projects are only retrieved once per run, regardless of amount of repository configs
+		// without a project or group
+		if run.allProjects == nil {
+			log.Info("Retrieving all projects")
+			allProjects, err := getAllProjects(client)
+			if err != nil {
+				return nil, err
 			}
+			run.allProjects = allProjects
+		}
+		projects = run.allProjects
+	}
 
-			if !intInSlice(repositoryConfig.Group, groupIDs) {
-				continue
-			}
+	var projectIDs []int
+	for _, project := range projects {
+		if project.Archived {
+			log.Tracef("Skipping archived project %d", project.ID)
+			continue
+		}
+
+		// Skip if container registry not enabled
+		if !project.ContainerRegistryEnabled {
+			log.Tracef("Container registry not enabled for project %d", project.ID)
+			continue
 		}
 
 		projectIDs = append(projectIDs, project.ID)
@@ -123,32 +395,43 @@ func getRepositoryProjects(cmd *cobra.Command, client *gitlab.Client, projects [
 	return projectIDs, nil
 }
 
-func getParentGroupIDsRecursive(client *gitlab.Client, id int) ([]int, error) {
-	var ids []int
-	next := id
-	for {
-		namespace, _, err := client.Namespaces.GetNamespace(next)
-		if err != nil {
-			return nil, fmt.Errorf("Failed to retrieve namespace: %s", err)
-		}
-		if namespace.ParentID == 0 {
-			break
-		}
+func processRepositoryProjects(cmd *cobra.Command, client api.Client, cfg *config.Config, repositoryConfig config.RepositoryConfig, projectIDs []int, run *cleanupRun, configReport *report.RepositoryConfig) error {
+	log.Debugf("Processing %d repository projects", len(projectIDs))
 
-		ids = append(ids, namespace.ParentID)
-		next = namespace.ParentID
-	}
+	// Repositories for group targets are retrieved for the whole group in a single listing, rather
+	// than per project, so projects without repositories don't incur any requests
+	var groupRepositories map[int][]*gitlab.RegistryRepository
+	if repositoryConfig.GroupID > 0 {
+		var exists bool
+		groupRepositories, exists = run.groupRepositories[repositoryConfig.GroupID]
+		if !exists {
+			log.Debugf("Retrieving all Gitlab registry repositories for group %d", repositoryConfig.GroupID)
+			var err error
+			groupRepositories, err = getAllGroupRepositories(client, repositoryConfig.GroupID)
+			if err != nil {
+				return fmt.Errorf("Error retrieving all Gitlab registry repositories for group %d: %s", repositoryConfig.GroupID, err)
+			}
 
-	return ids, nil
-}
+			if run.groupRepositories == nil {
+				run.groupRepositories = make(map[int]map[int][]*gitlab.RegistryRepository)
+			}
+			run.groupRepositories[repositoryConfig.GroupID] = groupRepositories
+		}
+	}
 
-func processRepositoryProjects(cmd *cobra.Command, client *gitlab.Client, cfg *config.Config, repositoryConfig config.RepositoryConfig, projectIDs []int) error {
-	log.Debugf("Processing %d repository projects", len(projectIDs))
 	for _, projectID := range projectIDs {
-		log.Debugf("Retrieving all Gitlab registry repositories for project %d", projectID)
-		repositories, err := getAllProjectRepositories(client, projectID)
-		if err != nil {
-			return fmt.Errorf("Error retrieving all Gitlab registry repositories for project %d: %s", projectID, err)
+		projectReport := configReport.AddProject(projectID)
+
+		var repositories []*gitlab.RegistryRepository
+		if groupRepositories != nil {
+			repositories = groupRepositories[projectID]
+		} else {
+			log.Debugf("Retrieving all Gitlab registry repositories for project %d", projectID)
+			var err error
+			repositories, err = getAllProjectRepositories(client, projectID)
+			if err != nil {
+				return fmt.Errorf("Error retrieving all Gitlab registry repositories for project %d: %s", projectID, err)
+			}
 		}
 
 		log.Debugf("Found %d registry repositories", len(repositories))
@@ -156,8 +439,10 @@ func processRepositoryProjects(cmd *cobra.Command, client *gitlab.Client, cfg *c
 		for _, repository := range repositories {
 			if repositoryConfig.Images == nil || stringInSlice(repository.Path, repositoryConfig.Images) {
 				log.Infof("Processing repository %s", repository.Path)
-				err := processRepositoryProjectPolicies(cmd, client, cfg, repository, repositoryConfig, projectID)
+				repositoryReport := projectReport.AddRepository(repository.ID, repository.Path)
+				err := processRepositoryProjectPolicies(cmd, client, cfg, repository, repositoryConfig, projectID, run, repositoryReport)
 				if err != nil {
+					repositoryReport.Error = err.Error()
 					return err
 				}
 				log.Infof("Finished processing repository %s", repository.Path)
@@ -181,23 +466,14 @@ func stringInSlice(str string, slice []string) bool {
 	return false
 }
 
-func intInSlice(v int, slice []int) bool {
-	for _, sliceInt := range slice {
-		if v == sliceInt {
-			return true
-		}
-	}
-	return false
-}
-
-func processRepositoryProjectPolicies(cmd *cobra.Command, client *gitlab.Client, cfg *config.Config, repository *gitlab.RegistryRepository, repositoryConfig config.RepositoryConfig, projectID int) error {
+func processRepositoryProjectPolicies(cmd *cobra.Command, client api.Client, cfg *config.Config, repository *gitlab.RegistryRepository, repositoryConfig config.RepositoryConfig, projectID int, run *cleanupRun, repositoryReport *report.Repository) error {
 	var policyFilter []string
 	if cmd.Flags().Changed("policy") {
 		policyFilter, _ = cmd.Flags().GetStringSlice("policy")
 	}
-	for _, policyName := range repositoryConfig.Policies {
-		log.Infof("Processing repository policy %s", policyName)
 
+	var policyCfgs []config.PolicyConfig
+	for _, policyName := range repositoryConfig.Policies {
 		if len(policyFilter) > 0 && !stringInSlice(policyName, policyFilter) {
 			log.Warnf("Skipping policy %s as not specified in policy flag", policyName)
 			continue
@@ -207,99 +483,270 @@ func processRepositoryProjectPolicies(cmd *cobra.Command, client *gitlab.Client,
 		if err != nil {
 			return err
 		}
+		policyCfgs = append(policyCfgs, policyCfg)
+	}
+
+	if len(policyCfgs) == 0 {
+		return nil
+	}
+
+	// Tags are retrieved once per repository and shared between policies
+	tags, err := getRepositoryTags(cmd, client, repository, projectID)
+	if err != nil {
+		return err
+	}
+	listedTags := tags
+	if cmd.Flags().Changed("as-of") {
+		tags = tagsCreatedBy(tags, run.asOf)
+	}
+	repositoryReport.Tags = len(tags)
 
-		err = processRepositoryProjectPolicy(cmd, client, repository, projectID, policyCfg)
+	ctx, err := newPolicyContext(client, projectID, repository, tags, listedTags, run.asOf, repositoryConfig, policyCfgs, run)
+	if err != nil {
+		return err
+	}
+
+	force, _ := cmd.Flags().GetBool("force")
+
+	var policyDeletions [][]plan.Deletion
+	for _, policyCfg := range policyCfgs {
+		log.Infof("Processing repository policy %s", policyCfg.Name)
+
+		deletions, err := processRepositoryProjectPolicy(repository, projectID, ctx, policyCfg, repositoryReport.AddPolicy(policyCfg.Name))
 		if err != nil {
 			return err
 		}
 
-		log.Infof("Finished processing repository policy %s", policyName)
+		err = checkDeletionLimits(fmt.Sprintf("policy %s", policyCfg.Name), len(deletions), len(tags), policyCfg.MaxDeletions, policyCfg.MaxDeletionPercent)
+		if err != nil {
+			if !force {
+				log.Errorf("Skipping repository %s: %s", repository.Path, err)
+				repositoryReport.Skipped = err.Error()
+				run.skipped = true
+				return nil
+			}
+			log.Warnf("Ignoring exceeded deletion limit for repository %s as force specified: %s", repository.Path, err)
+		}
+		policyDeletions = append(policyDeletions, deletions)
+
+		log.Infof("Finished processing repository policy %s", policyCfg.Name)
+	}
+
+	deletions, err := plan.Merge(repositoryConfig.PolicyMode, policyDeletions...)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Found %d tags for removal across %d policies", len(deletions), len(policyCfgs))
+
+	err = checkRepositoryDeletionLimits(cfg, repositoryConfig, run, len(deletions), len(tags))
+	if err != nil {
+		if !force {
+			log.Errorf("Skipping repository %s: %s", repository.Path, err)
+			repositoryReport.Skipped = err.Error()
+			run.skipped = true
+			return nil
+		}
+		log.Warnf("Ignoring exceeded deletion limit for repository %s as force specified: %s", repository.Path, err)
+	}
+	run.deletions += len(deletions)
+
+	if run.plan != nil {
+		log.Infof("Adding %d tags to plan", len(deletions))
+		run.plan.Add(deletions...)
+		for _, deletion := range deletions {
+			repositoryReport.Deleted = append(repositoryReport.Deleted, deletion.Tag)
+		}
+		return nil
+	}
+
+	// Only tags successfully removed are reported, as removal may fail part way through
+	removed, err := removeTags(cmd, client, deletions)
+	repositoryReport.Deleted = append(repositoryReport.Deleted, removed...)
+
+	return err
+}
+
+// checkRepositoryDeletionLimits checks count tags selected for removal from a repository containing total tags
+// against repository limits, falling back to global limits where not specified, and against the run limit
+func checkRepositoryDeletionLimits(cfg *config.Config, repositoryConfig config.RepositoryConfig, run *cleanupRun, count int, total int) error {
+	maxDeletions := repositoryConfig.MaxDeletions
+	if maxDeletions == 0 {
+		maxDeletions = cfg.MaxDeletions
+	}
+	maxDeletionPercent := repositoryConfig.MaxDeletionPercent
+	if maxDeletionPercent == 0 {
+		maxDeletionPercent = cfg.MaxDeletionPercent
+	}
+
+	err := checkDeletionLimits("repository", count, total, maxDeletions, maxDeletionPercent)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MaxRunDeletions > 0 && run.deletions+count > cfg.MaxRunDeletions {
+		return fmt.Errorf("Removing %d tags would exceed run max_run_deletions of %d, with %d tags already removed", count, cfg.MaxRunDeletions, run.deletions)
+	}
+
+	return nil
+}
+
+// checkDeletionLimits returns an error if count tags selected for removal from total tags exceeds
+// maxDeletions or maxDeletionPercent. Limits of zero are ignored
+func checkDeletionLimits(scope string, count int, total int, maxDeletions int, maxDeletionPercent float64) error {
+	if maxDeletions > 0 && count > maxDeletions {
+		return fmt.Errorf("Removing %d tags would exceed %s max_deletions of %d", count, scope, maxDeletions)
+	}
+
+	if maxDeletionPercent > 0 && total > 0 {
+		percent := float64(count) / float64(total) * 100
+		if percent > maxDeletionPercent {
+			return fmt.Errorf("Removing %d of %d tags (%.1f%%) would exceed %s max_deletion_percent of %g%%", count, total, percent, scope, maxDeletionPercent)
+		}
 	}
 
 	return nil
 }
 
-func processRepositoryProjectPolicy(cmd *cobra.Command, client *gitlab.Client, repository *gitlab.RegistryRepository, projectID int, policyCfg config.PolicyConfig) error {
+// getRepositoryTags retrieves details for all tags within repository
+func getRepositoryTags(cmd *cobra.Command, client api.Client, repository *gitlab.RegistryRepository, projectID int) ([]*gitlab.RegistryRepositoryTag, error) {
 	log.Debug("Retrieving tag metadata")
 	tagsMeta, err := getAllProjectRepositoryTags(client, repository, projectID)
 	if err != nil {
-		return fmt.Errorf("Failed retrieving tags: %w", err)
+		return nil, fmt.Errorf("Failed retrieving tags: %w", err)
 	}
 
-	var tags []*gitlab.RegistryRepositoryTag
-
 	log.Info("Retrieving tag details")
 
 	progressFlag, _ := cmd.Flags().GetBool("progress")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+	// Details are stored by index to retain tag ordering regardless of concurrency
+	tags := make([]*gitlab.RegistryRepositoryTag, len(tagsMeta))
 
 	bar := progress.NewProgress(progressFlag, len(tagsMeta))
 	bar.Start()
-	for _, tagMeta := range tagsMeta {
-		bar.Increment()
-		log.Debugf("Retrieving details for tag %s", tagMeta.Name)
-		tag, _, err := client.ContainerRegistry.GetRegistryRepositoryTagDetail(projectID, repository.ID, tagMeta.Name)
+	err = worker.Run(concurrency, len(tagsMeta), func(i int) error {
+		defer bar.Increment()
+		log.Debugf("Retrieving details for tag %s", tagsMeta[i].Name)
+		tag, _, err := client.GetRegistryRepositoryTagDetail(projectID, repository.ID, tagsMeta[i].Name)
 		if err != nil {
-			return fmt.Errorf("Failed retrieving tag detail: %w", err)
+			return fmt.Errorf("Failed retrieving tag detail for tag %s: %w", tagsMeta[i].Name, err)
 		}
-		tags = append(tags, tag)
-	}
+		tags[i] = tag
+		return nil
+	})
 	bar.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving tag details: %w", err)
+	}
 
+	return tags, nil
+}
+
+// processRepositoryProjectPolicy executes policy against tags for repository within ctx, returning tags selected
+// for removal and recording the decision for each tag against policyReport
+func processRepositoryProjectPolicy(repository *gitlab.RegistryRepository, projectID int, ctx policyContext, policyCfg config.PolicyConfig, policyReport *report.Policy) ([]plan.Deletion, error) {
 	log.WithFields(log.Fields{
 		"include": policyCfg.Filter.Include,
 		"exclude": policyCfg.Filter.Exclude,
 		"keep":    policyCfg.Filter.Keep,
 		"age":     policyCfg.Filter.Age,
+		"min_age": policyCfg.Filter.MinAge,
+		"order":   policyCfg.Filter.Order,
 	}).Debug("Executing filter pipeline")
 
-	f := filter.NewFilterPipeline(tags, policyCfg.Filter)
-	filteredTags, err := f.Execute(
-		filter.ExcludeLatestFilter,
-		filter.IncludeFilter,
-		filter.OrderedFilter,
-		filter.KeepFilter,
-		filter.AgeFilter,
-		filter.ExcludeFilter,
-	)
+	f := filter.NewFilterPipeline(ctx.tags, policyCfg.Filter)
+	filteredTags, err := f.ExecuteStages(policyStages(ctx)...)
 	if err != nil {
-		return fmt.Errorf("Failed to execute filter pipeline: %w", err)
+		return nil, fmt.Errorf("Failed to execute filter pipeline: %w", err)
 	}
 
 	log.Infof("Found %d tags for removal", len(filteredTags))
-	if len(filteredTags) > 0 {
-		log.Info("Removing tags")
-
-		dryRun, _ := cmd.Flags().GetBool("dry-run")
-
-		bar = progress.NewProgress(progressFlag, len(filteredTags))
-		bar.Start()
-		for _, filteredTag := range filteredTags {
-			bar.Increment()
-			logLine := fmt.Sprintf("Removing tag %s", filteredTag.Name)
-			if dryRun {
-				log.Warnf("[DRY RUN]: %s", logLine)
-			} else {
-				log.Info(logLine)
-				_, err := client.ContainerRegistry.DeleteRegistryRepositoryTag(projectID, repository.ID, filteredTag.Name)
-				if err != nil {
-					return fmt.Errorf("Failed to remove tag %s: %w", filteredTag.Name, err)
-				}
-			}
+
+	for _, tag := range ctx.tags {
+		if stage := f.KeptBy(tag.Name); len(stage) > 0 {
+			policyReport.AddTag(tag.Name, tag.Digest, report.ActionKeep, stage)
+		} else {
+			policyReport.AddTag(tag.Name, tag.Digest, report.ActionDelete, f.SelectedBy(tag.Name))
 		}
-		bar.Finish()
+	}
 
-		log.Infof("Finished removing %d tags", len(filteredTags))
+	var deletions []plan.Deletion
+	for _, filteredTag := range filteredTags {
+		deletions = append(deletions, plan.Deletion{
+			ProjectID:    projectID,
+			RepositoryID: repository.ID,
+			Repository:   repository.Path,
+			Tag:          filteredTag.Name,
+			Digest:       filteredTag.Digest,
+			Policy:       policyCfg.Name,
+			Stage:        f.SelectedBy(filteredTag.Name),
+		})
 	}
 
-	return nil
+	return deletions, nil
 }
 
-func getAllProjectRepositories(client *gitlab.Client, projectId int) ([]*gitlab.RegistryRepository, error) {
+// removeTags removes tags for deletions, returning names of tags successfully removed (or which would be
+// removed in dry-run mode)
+func removeTags(cmd *cobra.Command, client api.Client, deletions []plan.Deletion) ([]string, error) {
+	if len(deletions) == 0 {
+		return nil, nil
+	}
+
+	log.Info("Removing tags")
+
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	progressFlag, _ := cmd.Flags().GetBool("progress")
+	concurrency, _ := cmd.Flags().GetInt("concurrency")
+
+	// Each worker sets only the index it processes, so no locking is required
+	succeeded := make([]bool, len(deletions))
+
+	bar := progress.NewProgress(progressFlag, len(deletions))
+	bar.Start()
+	err := worker.Run(concurrency, len(deletions), func(i int) error {
+		defer bar.Increment()
+		logLine := fmt.Sprintf("Removing tag %s", deletions[i].Tag)
+		if dryRun {
+			log.Warnf("[DRY RUN]: %s", logLine)
+			succeeded[i] = true
+			return nil
+		}
+
+		log.Info(logLine)
+		_, err := client.DeleteRegistryRepositoryTag(deletions[i].ProjectID, deletions[i].RepositoryID, deletions[i].Tag)
+		if err != nil {
+			return fmt.Errorf("Failed to remove tag %s: %w", deletions[i].Tag, err)
+		}
+		succeeded[i] = true
+		return nil
+	})
+	bar.Finish()
+
+	var removed []string
+	for i, deletion := range deletions {
+		if succeeded[i] {
+			removed = append(removed, deletion.Tag)
+		}
+	}
+
+	if err != nil {
+		return removed, err
+	}
+
+	log.Infof("Finished removing %d tags", len(removed))
+
+	return removed, nil
+}
+
+func getAllProjectRepositories(client api.Client, projectId int) ([]*gitlab.RegistryRepository, error) {
 	var allRepositories []*gitlab.RegistryRepository
 	page := 1
 	for {
 		log.WithField("page", page).Trace(("Retrieving repositories"))
-		repositories, resp, err := client.ContainerRegistry.ListRegistryRepositories(projectId, &gitlab.ListRegistryRepositoriesOptions{Page: page})
+		repositories, resp, err := client.ListRegistryRepositories(projectId, &gitlab.ListRegistryRepositoriesOptions{Page: page})
 		if err != nil {
 			return nil, err
 		}
@@ -315,12 +762,42 @@ func getAllProjectRepositories(client *gitlab.Client, projectId int) ([]*gitlab.
 	return allRepositories, nil
 }
 
-func getAllProjectRepositoryTags(client *gitlab.Client, repository *gitlab.RegistryRepository, projectId int) ([]*gitlab.RegistryRepositoryTag, error) {
+// getAllGroupRepositories retrieves all registry repositories within group with ID groupID and its
+// subgroups, keyed by project ID
+func getAllGroupRepositories(client api.Client, groupID int) (map[int][]*gitlab.RegistryRepository, error) {
+	allRepositories := make(map[int][]*gitlab.RegistryRepository)
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving group repositories"))
+		repositories, resp, err := client.ListGroupRegistryRepositories(groupID, &api.ListGroupRegistryRepositoriesOptions{
+			PerPage: 100,
+			Page:    page,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, repository := range repositories {
+			r := repository.RegistryRepository
+			allRepositories[repository.ProjectID] = append(allRepositories[repository.ProjectID], &r)
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	return allRepositories, nil
+}
+
+func getAllProjectRepositoryTags(client api.Client, repository *gitlab.RegistryRepository, projectId int) ([]*gitlab.RegistryRepositoryTag, error) {
 	var allTags []*gitlab.RegistryRepositoryTag
 	page := 1
 	for {
 		log.WithField("page", page).Trace(("Retrieving tags"))
-		tags, resp, err := client.ContainerRegistry.ListRegistryRepositoryTags(projectId, repository.ID, &gitlab.ListRegistryRepositoryTagsOptions{Page: page})
+		tags, resp, err := client.ListRegistryRepositoryTags(projectId, repository.ID, &gitlab.ListRegistryRepositoryTagsOptions{Page: page})
 		if err != nil {
 			return nil, err
 		}
@@ -336,16 +813,47 @@ func getAllProjectRepositoryTags(client *gitlab.Client, repository *gitlab.Regis
 	return allTags, nil
 }
 
-func getAllProjects(client *gitlab.Client) ([]*gitlab.Project, error) {
+func getAllProjects(client api.Client) ([]*gitlab.Project, error) {
 	var allProjects []*gitlab.Project
 	page := 1
 	for {
 		log.WithField("page", page).Trace(("Retrieving projects"))
-		projects, resp, err := client.Projects.ListProjects(&gitlab.ListProjectsOptions{
+		projects, resp, err := client.ListProjects(&gitlab.ListProjectsOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+				Page:    page,
+			},
+			Archived: gitlab.Bool(false),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		allProjects = append(allProjects, projects...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	return allProjects, nil
+}
+
+func getAllGroupProjects(client api.Client, groupID int, includeSubgroups bool) ([]*gitlab.Project, error) {
+	var allProjects []*gitlab.Project
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving group projects"))
+		projects, resp, err := client.ListGroupProjects(groupID, &gitlab.ListGroupProjectsOptions{
 			ListOptions: gitlab.ListOptions{
 				PerPage: 100,
 				Page:    page,
 			},
+			Archived:         gitlab.Bool(false),
+			IncludeSubgroups: gitlab.Bool(includeSubgroups),
+			WithShared:       gitlab.Bool(false),
 		})
 		if err != nil {
 			return nil, err
@@ -0,0 +1,981 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api/fake"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/report"
+	"github.com/xanzy/go-gitlab"
+)
+
+// setupTest loads yaml config and substitutes the Gitlab client with a fake registry containing:
+//   - group 10 (parent) > group 11 (child)
+//   - project 1 (group 10) with repository 100 (group10/project1/app)
+//   - project 2 (group 11) with repository 200 (group10/group11/project2/app)
+//   - project 3 (group 10) with container registry disabled
+//   - project 4 (group 10) archived, with repository 400 (group10/project4/app)
+//
+// Each repository contains tags test1..test5 ordered by age (test1 oldest), plus latest
+func setupTest(t *testing.T, yaml string) *fake.Registry {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := fake.NewRegistry()
+	registry.AddNamespace(&gitlab.Namespace{ID: 10, FullPath: "group10"})
+	registry.AddNamespace(&gitlab.Namespace{ID: 11, FullPath: "group10/group11", ParentID: 10})
+
+	addProject := func(projectID int, namespaceID int, path string, registryEnabled bool, archived bool, repositoryID int) {
+		registry.AddProject(&gitlab.Project{
+			ID:                       projectID,
+			PathWithNamespace:        path,
+			ContainerRegistryEnabled: registryEnabled,
+			Archived:                 archived,
+			DefaultBranch:            "main",
+			Namespace:                &gitlab.ProjectNamespace{ID: namespaceID},
+		})
+		if !registryEnabled {
+			return
+		}
+
+		registry.AddRepository(projectID, &gitlab.RegistryRepository{
+			ID:       repositoryID,
+			Path:     path + "/app",
+			Location: "registry.example.com/" + path + "/app",
+		})
+
+		now := time.Now()
+		for i, name := range []string{"test1", "test2", "test3", "test4", "test5", "latest"} {
+			createdAt := now.Add(-time.Duration(10-i) * 24 * time.Hour)
+			registry.AddTag(repositoryID, &gitlab.RegistryRepositoryTag{
+				Name:      name,
+				Digest:    "sha256:" + path + name,
+				CreatedAt: &createdAt,
+			})
+		}
+	}
+
+	addProject(1, 10, "group10/project1", true, false, 100)
+	addProject(2, 11, "group10/group11/project2", true, false, 200)
+	addProject(3, 10, "group10/project3", false, false, 0)
+	addProject(4, 10, "group10/project4", true, true, 400)
+
+	original := newGitlabClient
+	newGitlabClient = func(cfg *config.Config) (api.Client, error) {
+		return registry, nil
+	}
+	t.Cleanup(func() {
+		newGitlabClient = original
+		viper.Reset()
+	})
+
+	return registry
+}
+
+func newTestExecuteCmd(t *testing.T, flags map[string]string) *cobra.Command {
+	cmd := ExecuteCmd()
+	for name, value := range flags {
+		err := cmd.Flags().Set(name, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return cmd
+}
+
+// planTags returns the sorted names of tags planned for removal within p
+func planTags(p *plan.Plan) []string {
+	var tags []string
+	for _, deletion := range p.Deletions {
+		tags = append(tags, deletion.Tag)
+	}
+	sort.Strings(tags)
+
+	return tags
+}
+
+const testPolicies = `
+policies:
+- name: keep2
+  filter:
+    include: ^test
+    keep: 2
+- name: removeall
+  filter:
+    include: .*
+`
+
+func TestRunCleanup(t *testing.T) {
+	t.Run("ProjectTarget_RemovesExpectedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+		assert.Equal(t, []string{"latest", "test1", "test2", "test3", "test4", "test5"}, registry.TagNames(200))
+	})
+
+	t.Run("GroupTarget_RemovesFromImmediateProjects", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- group: 10
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+		assert.Len(t, registry.TagNames(200), 6)
+		assert.Equal(t, 1, registry.Requests["ListGroupRegistryRepositories"])
+		assert.Equal(t, 0, registry.Requests["ListRegistryRepositories"])
+	})
+
+	t.Run("GroupTargetRecurse_RemovesFromSubgroupProjects", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- group: 10
+  recurse: true
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(200))
+		assert.Equal(t, 0, registry.Requests["ListProjects"])
+		assert.Equal(t, 1, registry.Requests["ListGroupRegistryRepositories"])
+		assert.Equal(t, 0, registry.Requests["ListRegistryRepositories"])
+	})
+
+	t.Run("GroupTargetArchivedProject_Skipped", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- group: 10
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest"}, registry.TagNames(100))
+		assert.Len(t, registry.TagNames(400), 6)
+	})
+
+	t.Run("ArchivedProject_Skipped", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- policies:
+  - removeall
+- project: 4
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.TagNames(400), 6)
+		assert.Equal(t, 1, registry.Requests["ListProjects"])
+	})
+
+	t.Run("ProjectPathTarget_RemovesExpectedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: group10/project1
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+		assert.Len(t, registry.TagNames(200), 6)
+	})
+
+	t.Run("GroupPathTarget_RemovesExpectedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- group: group10/group11
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.TagNames(100), 6)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(200))
+	})
+
+	t.Run("RepeatedGroupPath_ResolvedOnce", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- group: group10
+  recurse: true
+  policies:
+  - keep2
+- group: group10
+  recurse: true
+  images:
+  - group10/project1/app
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, registry.Requests["GetGroup"])
+		assert.Equal(t, 1, registry.Requests["ListGroupProjects"])
+		assert.Equal(t, 1, registry.Requests["ListGroupRegistryRepositories"])
+	})
+
+	t.Run("MultiplePolicies_RetrievesTagsOnce", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policy_mode: all
+  policies:
+  - keep2
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, registry.Requests["ListRegistryRepositoryTags"])
+		assert.Equal(t, 6, registry.Requests["GetRegistryRepositoryTagDetail"])
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("UnknownPathTarget_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: group10/missing
+  policies:
+  - keep2
+- project: 1
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("ImagesSpecified_RemovesFromMatchedRepositories", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- images:
+  - group10/group11/project2/app
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.TagNames(100), 6)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(200))
+	})
+
+	t.Run("DryRun_RemovesNothing", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"dry-run": "true"}), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("ConcurrencySpecified_RemovesExpectedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"concurrency": "4"}), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest"}, registry.TagNames(100))
+		assert.Equal(t, []string{"latest"}, registry.TagNames(200))
+	})
+
+	t.Run("PolicyModeAll_RemovesIntersection", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policy_mode: all
+  policies:
+  - keep2
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("DeletionLimitExceeded_SkipsRepository", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  max_deletion_percent: 50
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("DeletionLimitExceededWithForce_RemovesTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  max_deletion_percent: 50
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"force": "true"}), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 5)
+	})
+
+	t.Run("AgeDurationString_RemovesAgedTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: aged
+  filter:
+    include: ^test
+    age: 180h
+repositories:
+- project: 1
+  policies:
+  - aged
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("MinAgeSpecified_ProtectsFreshTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: removeall
+  filter:
+    include: .*
+    min_age: 8d12h
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test3", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("DailyRetentionSpecified_KeepsNewestPerDay", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: nightly
+  filter:
+    include: ^test
+    keep_daily: 8
+repositories:
+- project: 1
+  policies:
+  - nightly
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("DeletedBranchSpecified_RemovesDeletedBranchTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: branches
+  filter:
+    include: .*
+    deleted_branch:
+      pattern: ^(.+)-[0-9a-f]{8}$
+      grace_period: 1d
+repositories:
+- project: 1
+  policies:
+  - branches
+`)
+		createdAt := time.Now().Add(-2 * 24 * time.Hour)
+		for _, name := range []string{"main-00000001", "feature-x-00000002"} {
+			registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: name, Digest: "sha256:" + name, CreatedAt: &createdAt})
+		}
+		registry.AddBranch(1, &gitlab.Branch{Name: "main"})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 1)
+		assert.Equal(t, "feature-x-00000002", registry.Deleted[0].Tag)
+		assert.Equal(t, 1, registry.Requests["ListBranches"])
+	})
+
+	t.Run("DeletedBranchWithKeep_GracePeriodMeasuredFromKeptTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: branches
+  filter:
+    include: .*
+    keep: 1
+    keep_per_group: ^(.+)-[0-9a-f]{8}$
+    deleted_branch:
+      pattern: ^(.+)-[0-9a-f]{8}$
+      grace_period: 2d
+repositories:
+- project: 1
+  policies:
+  - branches
+`)
+		for name, age := range map[string]time.Duration{
+			"recent-00000001": 10 * 24 * time.Hour,
+			"recent-00000002": time.Hour,
+			"stale-00000003":  10 * 24 * time.Hour,
+			"stale-00000004":  5 * 24 * time.Hour,
+		} {
+			createdAt := time.Now().Add(-age)
+			registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: name, Digest: "sha256:" + name, CreatedAt: &createdAt})
+		}
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 1)
+		assert.Equal(t, "stale-00000003", registry.Deleted[0].Tag)
+	})
+
+	t.Run("MergeRequestSpecified_RemovesFinishedMergeRequestTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: review-apps
+  filter:
+    include: .*
+    merge_request:
+      pattern: ^mr-([0-9]+)$
+      grace_period: 1d
+repositories:
+- project: 1
+  policies:
+  - review-apps
+`)
+		createdAt := time.Now().Add(-5 * 24 * time.Hour)
+		for _, name := range []string{"mr-1", "mr-2"} {
+			registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: name, Digest: "sha256:" + name, CreatedAt: &createdAt})
+		}
+		mergedAt := time.Now().Add(-2 * 24 * time.Hour)
+		registry.AddMergeRequest(1, &gitlab.MergeRequest{IID: 1, State: "opened"})
+		registry.AddMergeRequest(1, &gitlab.MergeRequest{IID: 2, State: "merged", MergedAt: &mergedAt})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 1)
+		assert.Equal(t, "mr-2", registry.Deleted[0].Tag)
+		assert.Equal(t, 1, registry.Requests["ListProjectMergeRequests"])
+	})
+
+	t.Run("MergeRequestIIDsCaptured_RetrievesOnlyCapturedMergeRequests", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: review-apps
+  filter:
+    include: .*
+    merge_request:
+      pattern: ^mr-([0-9]+)$
+repositories:
+- project: 1
+  policies:
+  - review-apps
+`)
+		createdAt := time.Now().Add(-5 * 24 * time.Hour)
+		mergedAt := time.Now().Add(-2 * 24 * time.Hour)
+		for iid := 1; iid <= 300; iid++ {
+			registry.AddMergeRequest(1, &gitlab.MergeRequest{IID: iid, State: "merged", MergedAt: &mergedAt})
+			if iid <= 150 {
+				name := fmt.Sprintf("mr-%d", iid)
+				registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: name, Digest: "sha256:" + name, CreatedAt: &createdAt})
+			}
+		}
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 150)
+		assert.Equal(t, 2, registry.Requests["ListProjectMergeRequests"])
+	})
+
+	t.Run("MergeRequestSourceBranchCaptured_RetrievesAllMergeRequests", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: review-apps
+  filter:
+    include: .*
+    merge_request:
+      pattern: ^branch-(.+)$
+repositories:
+- project: 1
+  policies:
+  - review-apps
+`)
+		createdAt := time.Now().Add(-5 * 24 * time.Hour)
+		registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: "branch-feature-login", Digest: "sha256:login", CreatedAt: &createdAt})
+		mergedAt := time.Now().Add(-2 * 24 * time.Hour)
+		registry.AddMergeRequest(1, &gitlab.MergeRequest{IID: 1, SourceBranch: "feature/login", State: "merged", MergedAt: &mergedAt})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 1)
+		assert.Equal(t, "branch-feature-login", registry.Deleted[0].Tag)
+		assert.Equal(t, 1, registry.Requests["ListProjectMergeRequests"])
+	})
+
+	t.Run("EnvironmentsSpecified_KeepsDeployedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  environments:
+  - production/*
+  policies:
+  - keep2
+`)
+		registry.AddEnvironment(1, &gitlab.Environment{
+			ID:             1,
+			Name:           "production/uk",
+			State:          "available",
+			LastDeployment: &gitlab.Deployment{Ref: "test1"},
+		})
+		registry.AddEnvironment(1, &gitlab.Environment{
+			ID:             2,
+			Name:           "staging",
+			State:          "available",
+			LastDeployment: &gitlab.Deployment{Ref: "test2"},
+		})
+		registry.AddEnvironment(1, &gitlab.Environment{
+			ID:             3,
+			Name:           "production/us",
+			State:          "stopped",
+			LastDeployment: &gitlab.Deployment{Ref: "test3"},
+		})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test1", "test4", "test5"}, registry.TagNames(100))
+		assert.Equal(t, 1, registry.Requests["ListEnvironments"])
+		assert.Equal(t, 1, registry.Requests["GetEnvironment"])
+	})
+
+	t.Run("KeepGitTagsSpecified_KeepsGitTags", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: releases
+  filter:
+    include: ^test
+    keep_git_tags: true
+    keep_protected_tags: true
+repositories:
+- project: 1
+  policies:
+  - releases
+`)
+		registry.AddGitTag(1, &gitlab.Tag{Name: "test1"})
+		registry.AddProtectedTag(1, &gitlab.ProtectedTag{Name: "test5*"})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test1", "test5"}, registry.TagNames(100))
+		assert.Equal(t, 1, registry.Requests["ListTags"])
+		assert.Equal(t, 1, registry.Requests["ListProtectedTags"])
+	})
+
+	t.Run("InUseFileSpecified_KeepsInUseTags", func(t *testing.T) {
+		dir := t.TempDir()
+		textPath := filepath.Join(dir, "in-use.txt")
+		err := ioutil.WriteFile(textPath, []byte("registry.example.com/group10/project1/app:test1\n"), 0644)
+		assert.Nil(t, err)
+		jsonPath := filepath.Join(dir, "pods.json")
+		err = ioutil.WriteFile(jsonPath, []byte(`{"items": [{"spec": {"containers": [{"image": "registry.example.com/group10/project1/app@sha256:group10/project1test2"}]}}]}`), 0644)
+		assert.Nil(t, err)
+
+		registry := setupTest(t, testPolicies+`
+in_use_files:
+- `+textPath+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		err = runCleanup(newTestExecuteCmd(t, map[string]string{"in-use-file": jsonPath}), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test1", "test2"}, registry.TagNames(100))
+	})
+
+	t.Run("InUseFileNotFound_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"in-use-file": filepath.Join(t.TempDir(), "missing.txt")}), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("ScanFilesSpecified_KeepsReferencedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  scan_files:
+  - docker-compose.yml
+  - values*.yaml
+  policies:
+  - removeall
+`)
+		registry.AddFile(1, "docker-compose.yml", []byte(`
+services:
+  app:
+    image: registry.example.com/group10/project1/app:test1
+  other:
+    image: registry.example.com/group10/project2/app:test2
+  templated:
+    image: registry.example.com/group10/project1/app:${TAG}
+`))
+		registry.AddFile(1, "charts/app/values-production.yaml", []byte("image: \"registry.example.com/group10/project1/app:test3\"\n"))
+		registry.AddFile(1, "README.md", []byte("registry.example.com/group10/project1/app:test4"))
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test1", "test3"}, registry.TagNames(100))
+		assert.Equal(t, 1, registry.Requests["ListTree"])
+		assert.Equal(t, 2, registry.Requests["GetRawFile"])
+	})
+
+	t.Run("ScanFilesWithoutWildcards_RetrievesFilesDirectly", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  scan_files:
+  - docker-compose.yml
+  - deploy/missing.yaml
+  policies:
+  - removeall
+`)
+		registry.AddFile(1, "docker-compose.yml", []byte("image: registry.example.com/group10/project1/app:test1\n"))
+		registry.AddFile(1, "nested/docker-compose.yml", []byte("image: registry.example.com/group10/project1/app:test2\n"))
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "test1"}, registry.TagNames(100))
+		assert.Equal(t, 0, registry.Requests["ListTree"])
+		assert.Equal(t, 2, registry.Requests["GetRawFile"])
+	})
+
+	t.Run("NoDeletedBranchSpecified_DoesNotRetrieveBranches", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, registry.Requests["ListBranches"])
+		assert.Equal(t, 0, registry.Requests["ListProjectMergeRequests"])
+		assert.Equal(t, 0, registry.Requests["ListEnvironments"])
+		assert.Equal(t, 0, registry.Requests["ListTags"])
+		assert.Equal(t, 0, registry.Requests["ListTree"])
+	})
+
+	t.Run("AsOfSpecified_EvaluatesAgeAsOf", func(t *testing.T) {
+		registry := setupTest(t, `
+policies:
+- name: aged
+  filter:
+    include: ^test
+    age: 4d12h
+repositories:
+- project: 1
+  policies:
+  - aged
+`)
+
+		p := plan.NewPlan()
+		asOf := time.Now().Add(-3 * 24 * time.Hour).Format(time.RFC3339)
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": asOf, "dry-run": "true"}), &cleanupRun{plan: p})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test1", "test2", "test3"}, planTags(p))
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("AsOfSpecified_IgnoresTagsCreatedAfter", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		p := plan.NewPlan()
+		asOf := time.Now().Add(-204 * time.Hour).Format(time.RFC3339)
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": asOf, "dry-run": "true"}), &cleanupRun{plan: p})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test1", "test2"}, planTags(p))
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("AsOfSpecified_KeepsTagsSharingDigestWithTagCreatedAfter", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+		createdAt := time.Now().Add(-time.Hour)
+		registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: "stable", Digest: "sha256:group10/project1test1", CreatedAt: &createdAt})
+
+		p := plan.NewPlan()
+		asOf := time.Now().Add(-204 * time.Hour).Format(time.RFC3339)
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": asOf, "dry-run": "true"}), &cleanupRun{plan: p})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test2"}, planTags(p))
+	})
+
+	t.Run("AsOfNotSpecified_EvaluatesTagsCreatedDuringRun", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+		// Tag pushed after the run started, sharing a digest with a tag otherwise removed
+		createdAt := time.Now().Add(time.Hour)
+		registry.AddTag(100, &gitlab.RegistryRepositoryTag{Name: "stable", Digest: "sha256:group10/project1test1", CreatedAt: &createdAt})
+
+		err := runCleanup(newTestExecuteCmd(t, nil), &cleanupRun{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"latest", "stable", "test1", "test4", "test5"}, registry.TagNames(100))
+	})
+
+	t.Run("AsOfWithoutDryRun_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		asOf := time.Now().Add(-3 * 24 * time.Hour).Format(time.RFC3339)
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": asOf}), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Requests, 0)
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("FutureAsOf_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - removeall
+`)
+
+		asOf := time.Now().Add(24 * time.Hour).Format(time.RFC3339)
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": asOf, "dry-run": "true"}), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Requests, 0)
+	})
+
+	t.Run("InvalidAsOf_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies)
+
+		err := runCleanup(newTestExecuteCmd(t, map[string]string{"as-of": "yesterday", "dry-run": "true"}), &cleanupRun{})
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Requests, 0)
+	})
+
+	t.Run("PlanProvided_PlansWithoutRemoving", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		p := plan.NewPlan()
+		err := runCleanup(PlanCmd(), &cleanupRun{plan: p})
+
+		assert.Nil(t, err)
+		assert.Len(t, registry.Deleted, 0)
+		assert.Len(t, p.Deletions, 3)
+		assert.Equal(t, plan.Deletion{
+			ProjectID:    1,
+			RepositoryID: 100,
+			Repository:   "group10/project1/app",
+			Tag:          "test1",
+			Digest:       "sha256:group10/project1test1",
+			Policy:       "keep2",
+			Stage:        "KeepFilter",
+		}, p.Deletions[0])
+	})
+}
+
+func TestExecuteCleanup(t *testing.T) {
+	t.Run("RemovalFails_ReportsOnlyRemovedTags", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+		registry.FailDeletes["test2"] = true
+
+		path := filepath.Join(t.TempDir(), "report.json")
+		err := executeCleanup(newTestExecuteCmd(t, map[string]string{"report-file": path}), nil)
+
+		assert.NotNil(t, err)
+
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := &report.Report{}
+		err = json.Unmarshal(bytes, r)
+		assert.Nil(t, err)
+		// Removals already started when test2 fails may still complete
+		var removed []string
+		for _, deleted := range registry.Deleted {
+			removed = append(removed, deleted.Tag)
+		}
+		assert.Equal(t, len(removed), r.Totals.Deleted)
+
+		repository := r.RepositoryConfigs[0].Projects[0].Repositories[0]
+		assert.Equal(t, removed, repository.Deleted)
+		assert.Contains(t, repository.Deleted, "test1")
+		assert.NotContains(t, repository.Deleted, "test2")
+	})
+
+	t.Run("ReportFileSpecified_WritesReport", func(t *testing.T) {
+		setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		path := filepath.Join(t.TempDir(), "report.json")
+		err := executeCleanup(newTestExecuteCmd(t, map[string]string{"report-file": path}), nil)
+
+		assert.Nil(t, err)
+
+		bytes, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := &report.Report{}
+		err = json.Unmarshal(bytes, r)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, r.Totals.Repositories)
+		assert.Equal(t, 3, r.Totals.Deleted)
+
+		repository := r.RepositoryConfigs[0].Projects[0].Repositories[0]
+		assert.Equal(t, []string{"test1", "test2", "test3"}, repository.Deleted)
+		assert.Contains(t, repository.Policies[0].Tags, &report.TagDecision{
+			Tag:    "latest",
+			Digest: "sha256:group10/project1latest",
+			Action: report.ActionKeep,
+			Stage:  "ExcludeLatestFilter",
+		})
+		assert.Contains(t, repository.Policies[0].Tags, &report.TagDecision{
+			Tag:    "test5",
+			Digest: "sha256:group10/project1test5",
+			Action: report.ActionKeep,
+			Stage:  "KeepFilter",
+		})
+	})
+
+	t.Run("UnsupportedReportFormat_ReturnsError", func(t *testing.T) {
+		registry := setupTest(t, testPolicies)
+
+		err := executeCleanup(newTestExecuteCmd(t, map[string]string{"report": "xml"}), nil)
+
+		assert.NotNil(t, err)
+		assert.Len(t, registry.Requests, 0)
+	})
+}
@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/filter"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+	"github.com/xanzy/go-gitlab"
+)
+
+func ExplainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "explain",
+		Short: "Explains how applicable policies handle a single tag",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return explainTag(cmd, args)
+		},
+	}
+
+	cmd.Flags().String("project", "", "Project ID or full path")
+	cmd.Flags().String("image", "", "Repository path, e.g. team/app/image")
+	cmd.Flags().String("tag", "", "Tag name")
+	cmd.Flags().StringSlice("policy", []string{""}, "Limit policies to explain")
+	cmd.Flags().Int("concurrency", 1, "Maximum concurrent tag detail retrievals")
+	cmd.Flags().String("as-of", "", "Evaluates policies as of given RFC3339 timestamp or date, rather than now")
+	cmd.Flags().StringArray("in-use-file", []string{}, "File listing in-use images which are never removed, as JSON (e.g. kubectl get pods -o json) or plain text. Can be repeated")
+	cmd.MarkFlagRequired("project")
+	cmd.MarkFlagRequired("image")
+	cmd.MarkFlagRequired("tag")
+
+	return cmd
+}
+
+func explainTag(cmd *cobra.Command, args []string) error {
+	project, _ := cmd.Flags().GetString("project")
+	image, _ := cmd.Flags().GetString("image")
+	tagName, _ := cmd.Flags().GetString("tag")
+
+	var policyFilter []string
+	if cmd.Flags().Changed("policy") {
+		policyFilter, _ = cmd.Flags().GetStringSlice("policy")
+	}
+
+	asOf, err := getAsOf(cmd)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := newGitlabClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = resolveRepositoryTargets(client, cfg)
+	if err != nil {
+		return err
+	}
+
+	projectID, err := resolveProjectID(client, project)
+	if err != nil {
+		return err
+	}
+
+	repository, err := getProjectRepository(client, projectID, image)
+	if err != nil {
+		return err
+	}
+
+	tags, err := getRepositoryTags(cmd, client, repository, projectID)
+	if err != nil {
+		return err
+	}
+	listedTags := tags
+	if cmd.Flags().Changed("as-of") {
+		tags = tagsCreatedBy(tags, asOf)
+	}
+
+	var tag *gitlab.RegistryRepositoryTag
+	for _, t := range tags {
+		if t.Name == tagName {
+			tag = t
+		}
+	}
+	if tag == nil {
+		return fmt.Errorf("Tag %s not found in repository %s as of %s", tagName, image, asOf)
+	}
+
+	inUse, err := getInUse(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	run := &cleanupRun{inUse: inUse}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Tag %s (%s) in repository %s, created %s\n", tag.Name, tag.Digest, repository.Path, tag.CreatedAt.Format(time.RFC3339))
+
+	applicable := 0
+	for i, repositoryConfig := range cfg.Repositories {
+		targeted, err := repositoryConfigTargets(client, repositoryConfig, run, projectID, image)
+		if err != nil {
+			return err
+		}
+		if !targeted {
+			continue
+		}
+		applicable++
+
+		fmt.Fprintf(out, "\nRepository config %d (%s)\n", i+1, describeRepositoryConfig(repositoryConfig))
+
+		var policyCfgs []config.PolicyConfig
+		for _, policyName := range repositoryConfig.Policies {
+			if len(policyFilter) > 0 && !stringInSlice(policyName, policyFilter) {
+				continue
+			}
+
+			policyCfg, err := cfg.GetPolicyConfig(policyName)
+			if err != nil {
+				return err
+			}
+			policyCfgs = append(policyCfgs, policyCfg)
+		}
+
+		// Project data is cached within run, so is only retrieved once across repository configs
+		ctx, err := newPolicyContext(client, projectID, repository, tags, listedTags, asOf, repositoryConfig, policyCfgs, run)
+		if err != nil {
+			return err
+		}
+
+		var policyDeletions [][]plan.Deletion
+		for _, policyCfg := range policyCfgs {
+			selected, err := explainPolicy(out, ctx, tag, policyCfg)
+			if err != nil {
+				return err
+			}
+
+			var deletions []plan.Deletion
+			if selected {
+				deletions = append(deletions, plan.Deletion{Tag: tag.Name, Policy: policyCfg.Name})
+			}
+			policyDeletions = append(policyDeletions, deletions)
+		}
+
+		if len(policyDeletions) == 0 {
+			fmt.Fprintln(out, "  No policies to explain")
+			continue
+		}
+
+		deletions, err := plan.Merge(repositoryConfig.PolicyMode, policyDeletions...)
+		if err != nil {
+			return err
+		}
+
+		policyMode := repositoryConfig.PolicyMode
+		if len(policyMode) == 0 {
+			policyMode = plan.MergeAny
+		}
+		if len(deletions) > 0 {
+			fmt.Fprintf(out, "  Result (policy_mode %s): selected for removal, subject to deletion limits\n", policyMode)
+		} else {
+			fmt.Fprintf(out, "  Result (policy_mode %s): kept\n", policyMode)
+		}
+	}
+
+	if applicable == 0 {
+		fmt.Fprintln(out, "\nNo repository configs target this repository")
+	}
+
+	return nil
+}
+
+// explainPolicy executes policy against tags within ctx, writing the decision made by each stage for tag
+// to out. Returns true if the tag was selected for removal
+func explainPolicy(out io.Writer, ctx policyContext, tag *gitlab.RegistryRepositoryTag, policyCfg config.PolicyConfig) (bool, error) {
+	fmt.Fprintf(out, "  Policy %s\n", policyCfg.Name)
+
+	f := filter.NewFilterPipeline(ctx.tags, policyCfg.Filter)
+	_, err := f.ExecuteStages(policyStages(ctx)...)
+	if err != nil {
+		return false, fmt.Errorf("Failed to execute filter pipeline for policy %s: %w", policyCfg.Name, err)
+	}
+
+	for _, decision := range f.Decisions(tag.Name) {
+		fmt.Fprintf(out, "    %s: %s\n", decision.Stage, describeDecision(decision, policyCfg.Filter))
+	}
+
+	if stage := f.KeptBy(tag.Name); len(stage) > 0 {
+		fmt.Fprintf(out, "    => kept by %s\n", stage)
+		return false, nil
+	}
+
+	fmt.Fprintf(out, "    => selected for removal by %s\n", f.SelectedBy(tag.Name))
+	return true, nil
+}
+
+// describeDecision returns a human readable description of decision made by a policy stage
+func describeDecision(decision filter.Decision, cfg config.FilterConfig) string {
+	switch decision.Stage {
+	case "ExcludeLatestFilter":
+		if !decision.Selected {
+			return "latest tag is always kept"
+		}
+		return "not latest"
+	case "MinAgeFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("younger than min_age of %s", cfg.MinAge)
+		}
+		if cfg.MinAge <= 0 {
+			return "no min_age specified"
+		}
+		return fmt.Sprintf("older than min_age of %s", cfg.MinAge)
+	case "HighestPerMajorFilter":
+		if !decision.Selected {
+			return "highest release of its major version (keep_highest_per_major)"
+		}
+		return "not protected by keep_highest_per_major"
+	case "PatchesPerMinorFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("within the %d highest patch releases of its minor version (keep_patches_per_minor)", cfg.KeepPatchesPerMinor)
+		}
+		return "not protected by keep_patches_per_minor"
+	case "IncludeFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("didn't match include '%s'", cfg.Include)
+		}
+		return fmt.Sprintf("matched include '%s'", cfg.Include)
+	case "RetentionFilter":
+		if !decision.Selected {
+			return "newest tag within a keep_daily, keep_weekly or keep_monthly retention bucket"
+		}
+		return "not the newest tag within any retention bucket"
+	case "OrderedFilter":
+		order := cfg.Order
+		if len(order) == 0 {
+			order = filter.OrderCreated
+		}
+		return fmt.Sprintf("ordered position %d of %d by %s, oldest first", decision.Position, decision.Total, order)
+	case "KeepFilter":
+		if !decision.Selected && len(cfg.KeepPerGroup) > 0 {
+			return fmt.Sprintf("kept as one of the last %d tags within its group (keep, keep_per_group)", cfg.Keep)
+		}
+		if !decision.Selected {
+			return fmt.Sprintf("kept as one of the last %d tags (keep)", cfg.Keep)
+		}
+		return fmt.Sprintf("survived keep of %d", cfg.Keep)
+	case "AgeFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("too young for age of %s", cfg.Age)
+		}
+		if cfg.Age <= 0 {
+			return "no age specified"
+		}
+		return fmt.Sprintf("older than age of %s", cfg.Age)
+	case "DeletedBranchFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("branch captured by deleted_branch pattern '%s' still exists, is within grace period of %s, or not matched", cfg.DeletedBranch.Pattern, cfg.DeletedBranch.GracePeriod)
+		}
+		if len(cfg.DeletedBranch.Pattern) == 0 {
+			return "no deleted_branch specified"
+		}
+		return "branch no longer exists"
+	case "MergeRequestFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("merge request captured by merge_request pattern '%s' is open, within grace period of %s, not found or not matched", cfg.MergeRequest.Pattern, cfg.MergeRequest.GracePeriod)
+		}
+		if len(cfg.MergeRequest.Pattern) == 0 {
+			return "no merge_request specified"
+		}
+		return "merge request merged or closed"
+	case "ReferencedFilter":
+		if !decision.Selected {
+			return "referenced by a file within the project (scan_files)"
+		}
+		return "not referenced by any scanned file"
+	case "ExcludeFilter":
+		if !decision.Selected {
+			return fmt.Sprintf("matched exclude '%s'", cfg.Exclude)
+		}
+		if len(cfg.Exclude) == 0 {
+			return "no exclude specified"
+		}
+		return fmt.Sprintf("didn't match exclude '%s'", cfg.Exclude)
+	case "EnvironmentFilter":
+		if !decision.Selected {
+			return "deployed to a protected environment (environments)"
+		}
+		return "not deployed to any protected environment"
+	case "GitTagFilter":
+		if !decision.Selected {
+			return "corresponds to a Git tag or protected tag (keep_git_tags, keep_protected_tags)"
+		}
+		if !cfg.KeepGitTags && !cfg.KeepProtectedTags {
+			return "no keep_git_tags or keep_protected_tags specified"
+		}
+		return "doesn't correspond to any Git tag or protected tag"
+	case "InUseFilter":
+		if !decision.Selected {
+			return "in use, as listed within an in-use file"
+		}
+		return "not listed within any in-use file"
+	case "SharedDigestFilter":
+		if !decision.Selected {
+			return "digest shared with a kept tag"
+		}
+		return "digest not shared with any kept tag"
+	}
+
+	if !decision.Selected {
+		return "kept"
+	}
+	return "selected"
+}
+
+// repositoryConfigTargets returns true if repository config targets repository with path image within
+// project with ID projectID
+func repositoryConfigTargets(client api.Client, repositoryConfig config.RepositoryConfig, run *cleanupRun, projectID int, image string) (bool, error) {
+	if repositoryConfig.Images != nil && !stringInSlice(image, repositoryConfig.Images) {
+		return false, nil
+	}
+
+	if repositoryConfig.ProjectID > 0 {
+		return repositoryConfig.ProjectID == projectID, nil
+	}
+
+	projectIDs, err := getRepositoryProjects(client, repositoryConfig, run)
+	if err != nil {
+		return false, fmt.Errorf("Failed retrieving repository projects: %w", err)
+	}
+
+	for _, id := range projectIDs {
+		if id == projectID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func describeRepositoryConfig(repositoryConfig config.RepositoryConfig) string {
+	switch {
+	case len(repositoryConfig.Project) > 0:
+		return fmt.Sprintf("project %s", repositoryConfig.Project)
+	case len(repositoryConfig.Group) > 0:
+		return fmt.Sprintf("group %s", repositoryConfig.Group)
+	}
+
+	return "all projects"
+}
+
+// getProjectRepository retrieves registry repository with given path within project with ID projectID
+func getProjectRepository(client api.Client, projectID int, path string) (*gitlab.RegistryRepository, error) {
+	repositories, err := getAllProjectRepositories(client, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving registry repositories for project %d: %w", projectID, err)
+	}
+
+	for _, repository := range repositories {
+		if repository.Path == path {
+			return repository, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Repository %s not found in project %d", path, projectID)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainTag(t *testing.T) {
+	newTestExplainCmd := func(t *testing.T, tag string) (*bytes.Buffer, error) {
+		cmd := ExplainCmd()
+		for name, value := range map[string]string{
+			"project": "group10/project1",
+			"image":   "group10/project1/app",
+			"tag":     tag,
+		} {
+			err := cmd.Flags().Set(name, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		out := &bytes.Buffer{}
+		cmd.SetOut(out)
+
+		return out, explainTag(cmd, nil)
+	}
+
+	t.Run("SelectedTag_ExplainsStages", func(t *testing.T) {
+		registry := setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		out, err := newTestExplainCmd(t, "test2")
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "IncludeFilter: matched include '^test'")
+		assert.Contains(t, out.String(), "OrderedFilter: ordered position 2 of 5 by created, oldest first")
+		assert.Contains(t, out.String(), "KeepFilter: survived keep of 2")
+		assert.Contains(t, out.String(), "=> selected for removal by KeepFilter")
+		assert.Contains(t, out.String(), "Result (policy_mode any): selected for removal")
+		assert.Len(t, registry.Deleted, 0)
+	})
+
+	t.Run("KeptTag_ExplainsKeepingStage", func(t *testing.T) {
+		setupTest(t, testPolicies+`
+repositories:
+- project: 1
+  policies:
+  - keep2
+`)
+
+		out, err := newTestExplainCmd(t, "test5")
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "KeepFilter: kept as one of the last 2 tags (keep)")
+		assert.Contains(t, out.String(), "=> kept by KeepFilter")
+		assert.NotContains(t, out.String(), "\n    AgeFilter")
+		assert.Contains(t, out.String(), "Result (policy_mode any): kept")
+	})
+
+	t.Run("UntargetedRepository_ExplainsNoConfigs", func(t *testing.T) {
+		setupTest(t, testPolicies+`
+repositories:
+- group: 11
+  policies:
+  - keep2
+`)
+
+		out, err := newTestExplainCmd(t, "test2")
+
+		assert.Nil(t, err)
+		assert.Contains(t, out.String(), "No repository configs target this repository")
+	})
+
+	t.Run("UnknownTag_ReturnsError", func(t *testing.T) {
+		setupTest(t, testPolicies)
+
+		_, err := newTestExplainCmd(t, "missing")
+
+		assert.NotNil(t, err)
+	})
+}
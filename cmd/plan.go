@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+)
+
+func PlanCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "plan",
+		Short: "Writes planned tag removals to a plan file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return planCleanup(cmd, args)
+		},
+	}
+
+	cmd.Flags().String("plan", "plan.json", "Path to write plan file")
+	cmd.Flags().Bool("progress", false, "Outputs progress")
+	cmd.Flags().StringSlice("policy", []string{""}, "Limit policies to execute")
+	cmd.Flags().Int("concurrency", 1, "Maximum concurrent tag detail retrievals per repository")
+	cmd.Flags().Bool("force", false, "Ignores deletion limits")
+	cmd.Flags().String("as-of", "", "Evaluates policies as of given RFC3339 timestamp or date, rather than now")
+	cmd.Flags().StringArray("in-use-file", []string{}, "File listing in-use images which are never removed, as JSON (e.g. kubectl get pods -o json) or plain text. Can be repeated")
+
+	return cmd
+}
+
+func planCleanup(cmd *cobra.Command, args []string) error {
+	p := plan.NewPlan()
+
+	// Write plan regardless of errors, as plan may be partially complete
+	cleanupErr := runCleanup(cmd, &cleanupRun{plan: p})
+
+	planPath, _ := cmd.Flags().GetString("plan")
+	err := p.Write(planPath)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Written plan with %d tags for removal to %s", len(p.Deletions), planPath)
+
+	return cleanupErr
+}
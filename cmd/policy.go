@@ -0,0 +1,696 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/filter"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/inuse"
+	"github.com/xanzy/go-gitlab"
+)
+
+// policyContext holds the data policy filter stages are evaluated against for a single repository
+type policyContext struct {
+	// path is the path of the repository
+	path string
+	// tags are all tags within the repository evaluated by policies, i.e. excluding tags created after
+	// as-of where specified
+	tags []*gitlab.RegistryRepositoryTag
+	// listedTags are all tags currently within the repository, regardless of as-of
+	listedTags []*gitlab.RegistryRepositoryTag
+	// now is the time tag ages are evaluated against
+	now time.Time
+	// branches are the names of all branches within the project, only retrieved where required by policies
+	branches []string
+	// mergeRequests are merge requests within the project captured from tag names, only retrieved where
+	// required by policies
+	mergeRequests []*gitlab.MergeRequest
+	// environments are environments within the project matching the repository config, along with their
+	// last deployment
+	environments []*gitlab.Environment
+	// gitTags are the names of all Git tags within the project, only retrieved where required by policies
+	gitTags []string
+	// protectedTags are the names or wildcards of all protected tags within the project, only retrieved
+	// where required by policies
+	protectedTags []string
+	// inUse contains images in use, which are never removed
+	inUse *inuse.Set
+	// referenced contains images referenced by files within the project matching the repository config
+	referenced *inuse.Set
+}
+
+// newPolicyContext returns a policy context for tags within repository in project with ID projectID,
+// retrieving project data only where required by repositoryConfig or any of policyCfgs. listedTags are
+// all tags currently within the repository, of which tags are evaluated
+func newPolicyContext(client api.Client, projectID int, repository *gitlab.RegistryRepository, tags []*gitlab.RegistryRepositoryTag, listedTags []*gitlab.RegistryRepositoryTag, now time.Time, repositoryConfig config.RepositoryConfig, policyCfgs []config.PolicyConfig, run *cleanupRun) (policyContext, error) {
+	ctx := policyContext{
+		path:       repository.Path,
+		tags:       tags,
+		listedTags: listedTags,
+		now:        now,
+		inUse:      run.inUse,
+	}
+
+	if len(repositoryConfig.Environments) > 0 {
+		environments, err := getProjectEnvironments(client, projectID, repositoryConfig.Environments, run)
+		if err != nil {
+			return ctx, fmt.Errorf("Failed retrieving environments for project %d: %w", projectID, err)
+		}
+		ctx.environments = environments
+	}
+
+	if len(repositoryConfig.ScanFiles) > 0 {
+		referenced, err := getReferencedImages(client, projectID, repository, repositoryConfig.ScanFiles, run)
+		if err != nil {
+			return ctx, fmt.Errorf("Failed scanning files for project %d: %w", projectID, err)
+		}
+		ctx.referenced = referenced
+	}
+
+	var mergeRequestRefs []string
+	for _, policyCfg := range policyCfgs {
+		if len(policyCfg.Filter.MergeRequest.Pattern) > 0 {
+			refs, err := capturedRefs(ctx.tags, policyCfg.Filter.MergeRequest.Pattern)
+			if err != nil {
+				return ctx, err
+			}
+			for _, ref := range refs {
+				if !stringInSlice(ref, mergeRequestRefs) {
+					mergeRequestRefs = append(mergeRequestRefs, ref)
+				}
+			}
+		}
+	}
+	if len(mergeRequestRefs) > 0 {
+		mergeRequests, err := getProjectMergeRequests(client, projectID, mergeRequestRefs, run)
+		if err != nil {
+			return ctx, fmt.Errorf("Failed retrieving merge requests for project %d: %w", projectID, err)
+		}
+		ctx.mergeRequests = mergeRequests
+	}
+
+	for _, policyCfg := range policyCfgs {
+		if len(policyCfg.Filter.DeletedBranch.Pattern) > 0 && ctx.branches == nil {
+			branches, err := getProjectBranches(client, projectID, run)
+			if err != nil {
+				return ctx, fmt.Errorf("Failed retrieving branches for project %d: %w", projectID, err)
+			}
+			ctx.branches = branches
+		}
+		if policyCfg.Filter.KeepGitTags && ctx.gitTags == nil {
+			gitTags, err := getProjectGitTags(client, projectID, run)
+			if err != nil {
+				return ctx, fmt.Errorf("Failed retrieving Git tags for project %d: %w", projectID, err)
+			}
+			ctx.gitTags = gitTags
+		}
+		if policyCfg.Filter.KeepProtectedTags && ctx.protectedTags == nil {
+			protectedTags, err := getProjectProtectedTags(client, projectID, run)
+			if err != nil {
+				return ctx, fmt.Errorf("Failed retrieving protected tags for project %d: %w", projectID, err)
+			}
+			ctx.protectedTags = protectedTags
+		}
+	}
+
+	return ctx, nil
+}
+
+// policyStages returns the filter pipeline stages executed for each policy within ctx
+func policyStages(ctx policyContext) []filter.Stage {
+	return []filter.Stage{
+		filter.NewStage("ExcludeLatestFilter", filter.ExcludeLatestFilter),
+		filter.NewStage("MinAgeFilter", filter.NewMinAgeFilter(ctx.now)),
+		filter.NewStage("HighestPerMajorFilter", filter.HighestPerMajorFilter),
+		filter.NewStage("PatchesPerMinorFilter", filter.PatchesPerMinorFilter),
+		filter.NewStage("IncludeFilter", filter.IncludeFilter),
+		filter.NewStage("RetentionFilter", filter.NewRetentionFilter(ctx.now)),
+		filter.NewStage("OrderedFilter", filter.OrderedFilter),
+		filter.NewStage("KeepFilter", filter.KeepFilter),
+		filter.NewStage("AgeFilter", filter.NewAgeFilter(ctx.now)),
+		filter.NewStage("DeletedBranchFilter", filter.NewDeletedBranchFilter(ctx.now, ctx.branches, ctx.tags)),
+		filter.NewStage("MergeRequestFilter", filter.NewMergeRequestFilter(ctx.now, ctx.mergeRequests)),
+		filter.NewStage("ReferencedFilter", filter.NewReferencedFilter(ctx.referenced, ctx.path)),
+		filter.NewStage("ExcludeFilter", filter.ExcludeFilter),
+		filter.NewStage("EnvironmentFilter", filter.NewEnvironmentFilter(ctx.environments)),
+		filter.NewStage("GitTagFilter", filter.NewGitTagFilter(ctx.gitTags, ctx.protectedTags)),
+		filter.NewStage("InUseFilter", filter.NewInUseFilter(ctx.inUse, ctx.path)),
+		filter.NewStage("SharedDigestFilter", filter.NewSharedDigestFilter(ctx.listedTags)),
+	}
+}
+
+// getProjectBranches retrieves names of all branches within project with ID projectID, cached for the run
+func getProjectBranches(client api.Client, projectID int, run *cleanupRun) ([]string, error) {
+	if branches, exists := run.branches[projectID]; exists {
+		return branches, nil
+	}
+
+	log.Debugf("Retrieving branches for project %d", projectID)
+
+	branches := []string{}
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving branches"))
+		projectBranches, resp, err := client.ListBranches(projectID, &gitlab.ListBranchesOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+				Page:    page,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, branch := range projectBranches {
+			branches = append(branches, branch.Name)
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	if run.branches == nil {
+		run.branches = make(map[int][]string)
+	}
+	run.branches[projectID] = branches
+
+	return branches, nil
+}
+
+// mergeRequestIIDsPerRequest is the maximum amount of merge request IIDs queried within a single request
+const mergeRequestIIDsPerRequest = 100
+
+// projectMergeRequests holds merge requests within a project, retrieved where required by policies
+type projectMergeRequests struct {
+	// byIID caches merge requests by IID, or nil where no merge request exists with the IID
+	byIID map[int]*gitlab.MergeRequest
+	// all are all merge requests within the project, only listed where source branches are captured
+	all    []*gitlab.MergeRequest
+	listed bool
+}
+
+// capturedRefs returns the distinct values captured from tag names by the first capture group of pattern
+func capturedRefs(tags []*gitlab.RegistryRepositoryTag, pattern string) ([]string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid merge_request pattern %s: %w", pattern, err)
+	}
+
+	var refs []string
+	for _, tag := range tags {
+		match := re.FindStringSubmatch(tag.Name)
+		if len(match) > 1 && len(match[1]) > 0 && !stringInSlice(match[1], refs) {
+			refs = append(refs, match[1])
+		}
+	}
+
+	return refs, nil
+}
+
+// getProjectMergeRequests retrieves merge requests within project with ID projectID, in any state, for
+// refs captured from tag names. Numeric refs are queried by IID, whereas all merge requests are listed
+// where any ref is a source branch. Merge requests are cached for the run
+func getProjectMergeRequests(client api.Client, projectID int, refs []string, run *cleanupRun) ([]*gitlab.MergeRequest, error) {
+	mergeRequests, exists := run.mergeRequests[projectID]
+	if !exists {
+		mergeRequests = &projectMergeRequests{byIID: make(map[int]*gitlab.MergeRequest)}
+		if run.mergeRequests == nil {
+			run.mergeRequests = make(map[int]*projectMergeRequests)
+		}
+		run.mergeRequests[projectID] = mergeRequests
+	}
+
+	var iids []int
+	sourceBranches := false
+	for _, ref := range refs {
+		iid, err := strconv.Atoi(ref)
+		if err != nil || iid <= 0 {
+			sourceBranches = true
+			continue
+		}
+		iids = append(iids, iid)
+	}
+
+	// Source branches (or their slugs) can't be queried by IID, so all merge requests are required
+	if sourceBranches {
+		if !mergeRequests.listed {
+			log.Debugf("Retrieving merge requests for project %d", projectID)
+			all, err := listProjectMergeRequests(client, projectID, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, mergeRequest := range all {
+				mergeRequests.byIID[mergeRequest.IID] = mergeRequest
+			}
+			mergeRequests.all = all
+			mergeRequests.listed = true
+		}
+
+		return mergeRequests.all, nil
+	}
+
+	var uncached []int
+	for _, iid := range iids {
+		if _, exists := mergeRequests.byIID[iid]; !exists && !mergeRequests.listed {
+			uncached = append(uncached, iid)
+		}
+	}
+
+	for i := 0; i < len(uncached); i += mergeRequestIIDsPerRequest {
+		end := i + mergeRequestIIDsPerRequest
+		if end > len(uncached) {
+			end = len(uncached)
+		}
+		chunk := uncached[i:end]
+
+		log.Debugf("Retrieving %d merge requests for project %d", len(chunk), projectID)
+		chunkMergeRequests, err := listProjectMergeRequests(client, projectID, chunk)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, iid := range chunk {
+			mergeRequests.byIID[iid] = nil
+		}
+		for _, mergeRequest := range chunkMergeRequests {
+			mergeRequests.byIID[mergeRequest.IID] = mergeRequest
+		}
+	}
+
+	var iidMergeRequests []*gitlab.MergeRequest
+	for _, iid := range iids {
+		if mergeRequest := mergeRequests.byIID[iid]; mergeRequest != nil {
+			iidMergeRequests = append(iidMergeRequests, mergeRequest)
+		}
+	}
+
+	return iidMergeRequests, nil
+}
+
+// listProjectMergeRequests lists merge requests within project with ID projectID in any state, limited to
+// iids where specified
+func listProjectMergeRequests(client api.Client, projectID int, iids []int) ([]*gitlab.MergeRequest, error) {
+	mergeRequests := []*gitlab.MergeRequest{}
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving merge requests"))
+		projectMergeRequests, resp, err := client.ListProjectMergeRequests(projectID, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+				Page:    page,
+			},
+			IIDs:  iids,
+			State: gitlab.String("all"),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		mergeRequests = append(mergeRequests, projectMergeRequests...)
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	return mergeRequests, nil
+}
+
+// getProjectEnvironments retrieves available environments within project with ID projectID with names
+// matching any of patterns, along with their last deployment. Environments and deployments are cached
+// for the run
+func getProjectEnvironments(client api.Client, projectID int, patterns []string, run *cleanupRun) ([]*gitlab.Environment, error) {
+	environments, exists := run.environments[projectID]
+	if !exists {
+		log.Debugf("Retrieving environments for project %d", projectID)
+
+		environments = []*gitlab.Environment{}
+		page := 1
+		for {
+			log.WithField("page", page).Trace(("Retrieving environments"))
+			projectEnvironments, resp, err := client.ListEnvironments(projectID, &gitlab.ListEnvironmentsOptions{
+				PerPage: 100,
+				Page:    page,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			environments = append(environments, projectEnvironments...)
+
+			if resp.CurrentPage >= resp.TotalPages {
+				break
+			}
+
+			page++
+		}
+
+		if run.environments == nil {
+			run.environments = make(map[int][]*gitlab.Environment)
+		}
+		run.environments[projectID] = environments
+	}
+
+	var matched []*gitlab.Environment
+	for _, environment := range environments {
+		if environment.State != "available" || !environmentMatches(environment.Name, patterns) {
+			continue
+		}
+
+		// Environments aren't listed with their last deployment, so are retrieved individually
+		if environment.LastDeployment == nil {
+			log.Debugf("Retrieving environment %s for project %d", environment.Name, projectID)
+			detail, _, err := client.GetEnvironment(projectID, environment.ID)
+			if err != nil {
+				return nil, fmt.Errorf("Failed retrieving environment %s: %w", environment.Name, err)
+			}
+			environment.LastDeployment = detail.LastDeployment
+		}
+
+		matched = append(matched, environment)
+	}
+
+	return matched, nil
+}
+
+// environmentMatches returns true if environment name matches any of glob patterns
+func environmentMatches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getProjectGitTags retrieves names of all Git tags within project with ID projectID, cached for the run
+func getProjectGitTags(client api.Client, projectID int, run *cleanupRun) ([]string, error) {
+	if gitTags, exists := run.gitTags[projectID]; exists {
+		return gitTags, nil
+	}
+
+	log.Debugf("Retrieving Git tags for project %d", projectID)
+
+	gitTags := []string{}
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving Git tags"))
+		projectTags, resp, err := client.ListTags(projectID, &gitlab.ListTagsOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+				Page:    page,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range projectTags {
+			gitTags = append(gitTags, tag.Name)
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	if run.gitTags == nil {
+		run.gitTags = make(map[int][]string)
+	}
+	run.gitTags[projectID] = gitTags
+
+	return gitTags, nil
+}
+
+// getProjectProtectedTags retrieves names (or wildcards) of all protected tags within project with ID
+// projectID, cached for the run
+func getProjectProtectedTags(client api.Client, projectID int, run *cleanupRun) ([]string, error) {
+	if protectedTags, exists := run.protectedTags[projectID]; exists {
+		return protectedTags, nil
+	}
+
+	log.Debugf("Retrieving protected tags for project %d", projectID)
+
+	protectedTags := []string{}
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving protected tags"))
+		projectProtectedTags, resp, err := client.ListProtectedTags(projectID, &gitlab.ListProtectedTagsOptions{
+			PerPage: 100,
+			Page:    page,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tag := range projectProtectedTags {
+			protectedTags = append(protectedTags, tag.Name)
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	if run.protectedTags == nil {
+		run.protectedTags = make(map[int][]string)
+	}
+	run.protectedTags[projectID] = protectedTags
+
+	return protectedTags, nil
+}
+
+// getInUse parses in-use images from files specified by the in-use-file flag and config, returning nil
+// where no files are specified
+func getInUse(cmd *cobra.Command, cfg *config.Config) (*inuse.Set, error) {
+	paths, _ := cmd.Flags().GetStringArray("in-use-file")
+	paths = append(append([]string{}, cfg.InUseFiles...), paths...)
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	set := inuse.NewSet(nil)
+	for _, path := range paths {
+		images, err := inuse.ParseFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Infof("Loaded %d in-use images from %s", len(images), path)
+		for _, image := range images {
+			set.Add(image)
+		}
+	}
+
+	return set, nil
+}
+
+// projectFiles holds the files within the default branch of a project's Git repository
+type projectFiles struct {
+	ref string
+	// paths are all files within ref, only listed where scanned with wildcard patterns
+	paths  []string
+	listed bool
+	// contents caches file contents by path, retrieved where matched by repository configs. Files which
+	// don't exist are cached as nil
+	contents map[string][]byte
+}
+
+// getReferencedImages scans files within the default branch of project with ID projectID matching any of
+// patterns, returning images within the registry of repository referenced by them. Patterns without
+// wildcards are retrieved directly as paths, whereas wildcard patterns require listing all files. Files
+// are cached for the run
+func getReferencedImages(client api.Client, projectID int, repository *gitlab.RegistryRepository, patterns []string, run *cleanupRun) (*inuse.Set, error) {
+	host := strings.SplitN(repository.Location, "/", 2)[0]
+	if len(host) == 0 {
+		log.Warnf("Skipping file scan for repository %s without registry location", repository.Path)
+		return nil, nil
+	}
+
+	files, err := getProjectFiles(client, projectID, run)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extractors are compiled once per registry host for the run
+	extractor, exists := run.extractors[host]
+	if !exists {
+		extractor = inuse.NewExtractor(host)
+		if run.extractors == nil {
+			run.extractors = make(map[string]*inuse.Extractor)
+		}
+		run.extractors[host] = extractor
+	}
+
+	var filePaths []string
+	var globs []string
+	for _, pattern := range patterns {
+		if isGlob(pattern) {
+			globs = append(globs, pattern)
+		} else if !stringInSlice(pattern, filePaths) {
+			filePaths = append(filePaths, pattern)
+		}
+	}
+
+	if len(globs) > 0 {
+		err := listProjectFiles(client, projectID, files)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, filePath := range files.paths {
+			if fileMatches(filePath, globs) && !stringInSlice(filePath, filePaths) {
+				filePaths = append(filePaths, filePath)
+			}
+		}
+	}
+
+	referenced := inuse.NewSet(nil)
+	for _, filePath := range filePaths {
+		content, err := getProjectFile(client, projectID, files, filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, image := range extractor.Extract(content) {
+			log.Debugf("Found reference to %s within %s", image, filePath)
+			referenced.Add(image)
+		}
+	}
+
+	log.Debugf("Found %d referenced tags and digests within project %d", referenced.Len(), projectID)
+
+	return referenced, nil
+}
+
+// getProjectFiles retrieves the default branch of project with ID projectID, cached for the run
+func getProjectFiles(client api.Client, projectID int, run *cleanupRun) (*projectFiles, error) {
+	if files, exists := run.projectFiles[projectID]; exists {
+		return files, nil
+	}
+
+	project, _, err := client.GetProject(projectID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Failed retrieving project: %w", err)
+	}
+
+	files := &projectFiles{
+		ref:      project.DefaultBranch,
+		contents: make(map[string][]byte),
+	}
+
+	if run.projectFiles == nil {
+		run.projectFiles = make(map[int]*projectFiles)
+	}
+	run.projectFiles[projectID] = files
+
+	return files, nil
+}
+
+// listProjectFiles retrieves paths of all files within files' ref for project with ID projectID, where
+// not already listed
+func listProjectFiles(client api.Client, projectID int, files *projectFiles) error {
+	// Projects without a default branch have an empty Git repository
+	if files.listed || len(files.ref) == 0 {
+		return nil
+	}
+
+	log.Debugf("Retrieving files within branch %s for project %d", files.ref, projectID)
+
+	page := 1
+	for {
+		log.WithField("page", page).Trace(("Retrieving files"))
+		nodes, resp, err := client.ListTree(projectID, &gitlab.ListTreeOptions{
+			ListOptions: gitlab.ListOptions{
+				PerPage: 100,
+				Page:    page,
+			},
+			Ref:       gitlab.String(files.ref),
+			Recursive: gitlab.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed retrieving files: %w", err)
+		}
+
+		for _, node := range nodes {
+			if node.Type == "blob" {
+				files.paths = append(files.paths, node.Path)
+			}
+		}
+
+		if resp.CurrentPage >= resp.TotalPages {
+			break
+		}
+
+		page++
+	}
+
+	files.listed = true
+
+	return nil
+}
+
+// getProjectFile retrieves the content of file with filePath within files' ref for project with ID
+// projectID, or nil where the file doesn't exist
+func getProjectFile(client api.Client, projectID int, files *projectFiles, filePath string) ([]byte, error) {
+	if content, exists := files.contents[filePath]; exists {
+		return content, nil
+	}
+	if len(files.ref) == 0 {
+		return nil, nil
+	}
+
+	log.Debugf("Retrieving file %s for project %d", filePath, projectID)
+	content, resp, err := client.GetRawFile(projectID, filePath, &gitlab.GetRawFileOptions{Ref: gitlab.String(files.ref)})
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return nil, fmt.Errorf("Failed retrieving file %s: %w", filePath, err)
+		}
+
+		log.Debugf("File %s not found for project %d", filePath, projectID)
+		content = nil
+	}
+	files.contents[filePath] = content
+
+	return content, nil
+}
+
+// isGlob returns true if pattern contains glob metacharacters
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
+
+// fileMatches returns true if file with given path matches any of glob patterns. Patterns without a /
+// are matched against the file name within any directory, otherwise against the full path
+func fileMatches(filePath string, patterns []string) bool {
+	for _, pattern := range patterns {
+		name := filePath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(filePath)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,9 +1,14 @@
 package cmd
 
 import (
+	"fmt"
+
+	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
 )
 
 var rootCmd = &cobra.Command{
@@ -29,6 +34,10 @@ func init() {
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
 
 	rootCmd.AddCommand(ExecuteCmd())
+	rootCmd.AddCommand(PlanCmd())
+	rootCmd.AddCommand(ApplyCmd())
+	rootCmd.AddCommand(ValidateCmd())
+	rootCmd.AddCommand(ExplainCmd())
 }
 
 func initConfig() {
@@ -57,3 +66,27 @@ func initLogging() {
 		log.SetLevel(log.TraceLevel)
 	}
 }
+
+func loadConfig() (*config.Config, error) {
+	cfg := &config.Config{}
+	err := viper.Unmarshal(cfg, func(c *mapstructure.DecoderConfig) {
+		c.TagName = "yaml"
+		c.DecodeHook = mapstructure.ComposeDecodeHookFunc(config.DurationDecodeHook, c.DecodeHook)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// newGitlabClient returns a new Gitlab client. Declared as a variable to allow the client to be
+// substituted in tests
+var newGitlabClient = func(cfg *config.Config) (api.Client, error) {
+	client, err := api.NewClient(viper.GetString("access_token"), viper.GetString("url"), cfg.Client)
+	if err != nil {
+		return nil, fmt.Errorf("Failed initialising Gitlab client: %s", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/validate"
+)
+
+func ValidateCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "validate",
+		Short: "Validates config without making any API calls",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return validateConfig(cmd, args)
+		},
+	}
+}
+
+func validateConfig(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("config")
+	cfg, err := config.ParseStrict(path)
+	if err != nil {
+		return err
+	}
+
+	errs := validate.Config(cfg)
+	for _, err := range errs {
+		log.Error(err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("Config validation failed with %d errors", len(errs))
+	}
+
+	log.Infof("Config %s is valid", path)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/xanzy/go-gitlab"
+	"golang.org/x/time/rate"
+)
+
+const (
+	defaultMaxRetries = 5
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 30 * time.Second
+)
+
+// Client represents the Gitlab API operations used to clean up container registries
+type Client interface {
+	ListProjects(opt *gitlab.ListProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error)
+	GetProject(pid interface{}, opt *gitlab.GetProjectOptions) (*gitlab.Project, *gitlab.Response, error)
+	GetGroup(gid interface{}) (*gitlab.Group, *gitlab.Response, error)
+	ListGroupProjects(gid interface{}, opt *gitlab.ListGroupProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error)
+	ListRegistryRepositories(pid interface{}, opt *gitlab.ListRegistryRepositoriesOptions) ([]*gitlab.RegistryRepository, *gitlab.Response, error)
+	ListGroupRegistryRepositories(gid int, opt *ListGroupRegistryRepositoriesOptions) ([]*GroupRegistryRepository, *gitlab.Response, error)
+	ListRegistryRepositoryTags(pid interface{}, repository int, opt *gitlab.ListRegistryRepositoryTagsOptions) ([]*gitlab.RegistryRepositoryTag, *gitlab.Response, error)
+	GetRegistryRepositoryTagDetail(pid interface{}, repository int, tagName string) (*gitlab.RegistryRepositoryTag, *gitlab.Response, error)
+	DeleteRegistryRepositoryTag(pid interface{}, repository int, tagName string) (*gitlab.Response, error)
+	ListBranches(pid interface{}, opt *gitlab.ListBranchesOptions) ([]*gitlab.Branch, *gitlab.Response, error)
+	ListProjectMergeRequests(pid interface{}, opt *gitlab.ListProjectMergeRequestsOptions) ([]*gitlab.MergeRequest, *gitlab.Response, error)
+	ListEnvironments(pid interface{}, opt *gitlab.ListEnvironmentsOptions) ([]*gitlab.Environment, *gitlab.Response, error)
+	GetEnvironment(pid interface{}, environment int) (*gitlab.Environment, *gitlab.Response, error)
+	ListTags(pid interface{}, opt *gitlab.ListTagsOptions) ([]*gitlab.Tag, *gitlab.Response, error)
+	ListProtectedTags(pid interface{}, opt *gitlab.ListProtectedTagsOptions) ([]*gitlab.ProtectedTag, *gitlab.Response, error)
+	ListTree(pid interface{}, opt *gitlab.ListTreeOptions) ([]*gitlab.TreeNode, *gitlab.Response, error)
+	GetRawFile(pid interface{}, fileName string, opt *gitlab.GetRawFileOptions) ([]byte, *gitlab.Response, error)
+}
+
+// GroupRegistryRepository represents a registry repository listed for a group, which unlike
+// repositories listed for a project includes the ID of the owning project
+type GroupRegistryRepository struct {
+	gitlab.RegistryRepository
+	ProjectID int `json:"project_id"`
+}
+
+// ListGroupRegistryRepositoriesOptions represents the available ListGroupRegistryRepositories() options
+type ListGroupRegistryRepositoriesOptions gitlab.ListOptions
+
+// gitlabClient implements Client using the Gitlab API
+type gitlabClient struct {
+	client *gitlab.Client
+}
+
+// NewClient returns a new Gitlab client with rate limiting and retries configured from cfg
+func NewClient(token string, url string, cfg config.ClientConfig) (Client, error) {
+	client, err := gitlab.NewClient(token,
+		gitlab.WithBaseURL(url),
+		gitlab.WithHTTPClient(&http.Client{
+			Transport: newRetryTransport(cleanhttp.DefaultPooledTransport(), cfg),
+		}),
+		// Retries are handled by retryTransport
+		gitlab.WithoutRetries(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitlabClient{client: client}, nil
+}
+
+func (c *gitlabClient) ListProjects(opt *gitlab.ListProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error) {
+	return c.client.Projects.ListProjects(opt)
+}
+
+func (c *gitlabClient) GetProject(pid interface{}, opt *gitlab.GetProjectOptions) (*gitlab.Project, *gitlab.Response, error) {
+	return c.client.Projects.GetProject(pid, opt)
+}
+
+func (c *gitlabClient) GetGroup(gid interface{}) (*gitlab.Group, *gitlab.Response, error) {
+	return c.client.Groups.GetGroup(gid)
+}
+
+func (c *gitlabClient) ListGroupProjects(gid interface{}, opt *gitlab.ListGroupProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error) {
+	return c.client.Groups.ListGroupProjects(gid, opt)
+}
+
+func (c *gitlabClient) ListRegistryRepositories(pid interface{}, opt *gitlab.ListRegistryRepositoriesOptions) ([]*gitlab.RegistryRepository, *gitlab.Response, error) {
+	return c.client.ContainerRegistry.ListRegistryRepositories(pid, opt)
+}
+
+// ListGroupRegistryRepositories lists registry repositories for all projects within group with ID gid
+// and its subgroups. The group endpoint isn't provided by go-gitlab, so the request is built directly
+func (c *gitlabClient) ListGroupRegistryRepositories(gid int, opt *ListGroupRegistryRepositoriesOptions) ([]*GroupRegistryRepository, *gitlab.Response, error) {
+	req, err := c.client.NewRequest(http.MethodGet, fmt.Sprintf("groups/%d/registry/repositories", gid), opt, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var repositories []*GroupRegistryRepository
+	resp, err := c.client.Do(req, &repositories)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return repositories, resp, nil
+}
+
+func (c *gitlabClient) ListRegistryRepositoryTags(pid interface{}, repository int, opt *gitlab.ListRegistryRepositoryTagsOptions) ([]*gitlab.RegistryRepositoryTag, *gitlab.Response, error) {
+	return c.client.ContainerRegistry.ListRegistryRepositoryTags(pid, repository, opt)
+}
+
+func (c *gitlabClient) GetRegistryRepositoryTagDetail(pid interface{}, repository int, tagName string) (*gitlab.RegistryRepositoryTag, *gitlab.Response, error) {
+	return c.client.ContainerRegistry.GetRegistryRepositoryTagDetail(pid, repository, tagName)
+}
+
+func (c *gitlabClient) DeleteRegistryRepositoryTag(pid interface{}, repository int, tagName string) (*gitlab.Response, error) {
+	return c.client.ContainerRegistry.DeleteRegistryRepositoryTag(pid, repository, tagName)
+}
+
+func (c *gitlabClient) ListBranches(pid interface{}, opt *gitlab.ListBranchesOptions) ([]*gitlab.Branch, *gitlab.Response, error) {
+	return c.client.Branches.ListBranches(pid, opt)
+}
+
+func (c *gitlabClient) ListProjectMergeRequests(pid interface{}, opt *gitlab.ListProjectMergeRequestsOptions) ([]*gitlab.MergeRequest, *gitlab.Response, error) {
+	return c.client.MergeRequests.ListProjectMergeRequests(pid, opt)
+}
+
+func (c *gitlabClient) ListEnvironments(pid interface{}, opt *gitlab.ListEnvironmentsOptions) ([]*gitlab.Environment, *gitlab.Response, error) {
+	return c.client.Environments.ListEnvironments(pid, opt)
+}
+
+func (c *gitlabClient) GetEnvironment(pid interface{}, environment int) (*gitlab.Environment, *gitlab.Response, error) {
+	return c.client.Environments.GetEnvironment(pid, environment)
+}
+
+func (c *gitlabClient) ListTags(pid interface{}, opt *gitlab.ListTagsOptions) ([]*gitlab.Tag, *gitlab.Response, error) {
+	return c.client.Tags.ListTags(pid, opt)
+}
+
+func (c *gitlabClient) ListProtectedTags(pid interface{}, opt *gitlab.ListProtectedTagsOptions) ([]*gitlab.ProtectedTag, *gitlab.Response, error) {
+	return c.client.ProtectedTags.ListProtectedTags(pid, opt)
+}
+
+func (c *gitlabClient) ListTree(pid interface{}, opt *gitlab.ListTreeOptions) ([]*gitlab.TreeNode, *gitlab.Response, error) {
+	return c.client.Repositories.ListTree(pid, opt)
+}
+
+func (c *gitlabClient) GetRawFile(pid interface{}, fileName string, opt *gitlab.GetRawFileOptions) ([]byte, *gitlab.Response, error) {
+	return c.client.RepositoryFiles.GetRawFile(pid, fileName, opt)
+}
+
+func newRetryTransport(next http.RoundTripper, cfg config.ClientConfig) *retryTransport {
+	t := &retryTransport{
+		next:       next,
+		limiter:    rate.NewLimiter(rate.Inf, 0),
+		maxRetries: defaultMaxRetries,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+
+	if cfg.RequestsPerSecond > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		t.limiter = rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), burst)
+	}
+	if cfg.MaxRetries != nil {
+		t.maxRetries = *cfg.MaxRetries
+	}
+	if cfg.MinBackoff > 0 {
+		t.minBackoff = cfg.MinBackoff
+	}
+	if cfg.MaxBackoff > 0 {
+		t.maxBackoff = cfg.MaxBackoff
+	}
+	if t.maxBackoff < t.minBackoff {
+		t.maxBackoff = t.minBackoff
+	}
+
+	return t
+}
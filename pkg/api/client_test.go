@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+)
+
+func TestGitlabClient_ListGroupRegistryRepositories(t *testing.T) {
+	t.Run("RepositoriesReturned_IncludesProjectID", func(t *testing.T) {
+		var path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"id":100,"path":"group10/project1/app","project_id":1}]`))
+		}))
+		defer server.Close()
+
+		client, err := NewClient("token", server.URL, config.ClientConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		repositories, _, err := client.ListGroupRegistryRepositories(10, &ListGroupRegistryRepositoriesOptions{Page: 1})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "/api/v4/groups/10/registry/repositories", path)
+		assert.Len(t, repositories, 1)
+		assert.Equal(t, 100, repositories[0].ID)
+		assert.Equal(t, "group10/project1/app", repositories[0].Path)
+		assert.Equal(t, 1, repositories[0].ProjectID)
+	})
+}
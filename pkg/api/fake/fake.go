@@ -0,0 +1,675 @@
+package fake
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/api"
+	"github.com/xanzy/go-gitlab"
+)
+
+const defaultPerPage = 20
+
+var _ api.Client = &Registry{}
+
+// DeletedTag represents a tag removed from the fake registry
+type DeletedTag struct {
+	ProjectID    int
+	RepositoryID int
+	Tag          string
+}
+
+// Registry is an in-memory implementation of api.Client, for testing without network access
+type Registry struct {
+	mu sync.Mutex
+
+	projects      []*gitlab.Project
+	namespaces    map[int]*gitlab.Namespace
+	repositories  map[int][]*gitlab.RegistryRepository
+	tags          map[int][]*gitlab.RegistryRepositoryTag
+	branches      map[int][]*gitlab.Branch
+	mergeRequests map[int][]*gitlab.MergeRequest
+	environments  map[int][]*gitlab.Environment
+	gitTags       map[int][]*gitlab.Tag
+	protectedTags map[int][]*gitlab.ProtectedTag
+	files         map[int]map[string][]byte
+
+	// Deleted records all tags removed from the registry, in order of removal
+	Deleted []DeletedTag
+	// Requests records the count of requests made to each method
+	Requests map[string]int
+	// FailDeletes contains names of tags whose removal fails with a server error
+	FailDeletes map[string]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		namespaces:    make(map[int]*gitlab.Namespace),
+		repositories:  make(map[int][]*gitlab.RegistryRepository),
+		tags:          make(map[int][]*gitlab.RegistryRepositoryTag),
+		branches:      make(map[int][]*gitlab.Branch),
+		mergeRequests: make(map[int][]*gitlab.MergeRequest),
+		environments:  make(map[int][]*gitlab.Environment),
+		gitTags:       make(map[int][]*gitlab.Tag),
+		protectedTags: make(map[int][]*gitlab.ProtectedTag),
+		files:         make(map[int]map[string][]byte),
+		Requests:      make(map[string]int),
+		FailDeletes:   make(map[string]bool),
+	}
+}
+
+// AddNamespace adds a namespace (group) to the registry
+func (r *Registry) AddNamespace(namespace *gitlab.Namespace) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.namespaces[namespace.ID] = namespace
+}
+
+// AddProject adds a project to the registry
+func (r *Registry) AddProject(project *gitlab.Project) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.projects = append(r.projects, project)
+}
+
+// AddRepository adds a container registry repository to project with ID projectID
+func (r *Registry) AddRepository(projectID int, repository *gitlab.RegistryRepository) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.repositories[projectID] = append(r.repositories[projectID], repository)
+}
+
+// AddTag adds a tag to repository with ID repositoryID
+func (r *Registry) AddTag(repositoryID int, tag *gitlab.RegistryRepositoryTag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tags[repositoryID] = append(r.tags[repositoryID], tag)
+}
+
+// AddBranch adds a branch to project with ID projectID
+func (r *Registry) AddBranch(projectID int, branch *gitlab.Branch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.branches[projectID] = append(r.branches[projectID], branch)
+}
+
+// AddMergeRequest adds a merge request to project with ID projectID
+func (r *Registry) AddMergeRequest(projectID int, mergeRequest *gitlab.MergeRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.mergeRequests[projectID] = append(r.mergeRequests[projectID], mergeRequest)
+}
+
+// AddEnvironment adds an environment, along with its last deployment, to project with ID projectID
+func (r *Registry) AddEnvironment(projectID int, environment *gitlab.Environment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.environments[projectID] = append(r.environments[projectID], environment)
+}
+
+// AddGitTag adds a Git tag to project with ID projectID
+func (r *Registry) AddGitTag(projectID int, tag *gitlab.Tag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.gitTags[projectID] = append(r.gitTags[projectID], tag)
+}
+
+// AddProtectedTag adds a protected tag name or wildcard to project with ID projectID
+func (r *Registry) AddProtectedTag(projectID int, tag *gitlab.ProtectedTag) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.protectedTags[projectID] = append(r.protectedTags[projectID], tag)
+}
+
+// AddFile adds a file with given path and content to the Git repository of project with ID projectID
+func (r *Registry) AddFile(projectID int, path string, content []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.files[projectID] == nil {
+		r.files[projectID] = make(map[string][]byte)
+	}
+	r.files[projectID][path] = content
+}
+
+// TagNames returns the sorted names of tags remaining in repository with ID repositoryID
+func (r *Registry) TagNames(repositoryID int) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var names []string
+	for _, tag := range r.tags[repositoryID] {
+		names = append(names, tag.Name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func (r *Registry) ListProjects(opt *gitlab.ListProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListProjects"]++
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+	}
+
+	var projects []*gitlab.Project
+	for _, project := range r.projects {
+		if opt != nil && opt.Archived != nil && *opt.Archived != project.Archived {
+			continue
+		}
+		projects = append(projects, project)
+	}
+
+	start, end, resp := paginate(len(projects), page, perPage)
+	return projects[start:end], resp, nil
+}
+
+func (r *Registry) GetProject(pid interface{}, opt *gitlab.GetProjectOptions) (*gitlab.Project, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["GetProject"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	return project, response(http.StatusOK), nil
+}
+
+// GetGroup returns group represented by namespace with ID or full path gid
+func (r *Registry) GetGroup(gid interface{}) (*gitlab.Group, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["GetGroup"]++
+
+	namespace := r.namespace(gid)
+	if namespace == nil {
+		return nil, response(http.StatusNotFound), notFound("group", gid)
+	}
+
+	return &gitlab.Group{
+		ID:       namespace.ID,
+		Name:     namespace.Name,
+		Path:     namespace.Path,
+		FullPath: namespace.FullPath,
+		ParentID: namespace.ParentID,
+	}, response(http.StatusOK), nil
+}
+
+func (r *Registry) ListGroupProjects(gid interface{}, opt *gitlab.ListGroupProjectsOptions) ([]*gitlab.Project, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListGroupProjects"]++
+
+	group := r.namespace(gid)
+	if group == nil {
+		return nil, response(http.StatusNotFound), notFound("group", gid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	includeSubgroups := false
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+		includeSubgroups = opt.IncludeSubgroups != nil && *opt.IncludeSubgroups
+	}
+
+	var projects []*gitlab.Project
+	for _, project := range r.projects {
+		if opt != nil && opt.Archived != nil && *opt.Archived != project.Archived {
+			continue
+		}
+		if project.Namespace.ID == group.ID || (includeSubgroups && r.isDescendant(project.Namespace.ID, group.ID)) {
+			projects = append(projects, project)
+		}
+	}
+
+	start, end, resp := paginate(len(projects), page, perPage)
+	return projects[start:end], resp, nil
+}
+
+func (r *Registry) ListRegistryRepositories(pid interface{}, opt *gitlab.ListRegistryRepositoriesOptions) ([]*gitlab.RegistryRepository, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListRegistryRepositories"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(gitlab.ListOptions(*opt))
+	}
+
+	repositories := r.repositories[project.ID]
+	start, end, resp := paginate(len(repositories), page, perPage)
+	return repositories[start:end], resp, nil
+}
+
+// ListGroupRegistryRepositories lists repositories for projects within group with ID gid and all of
+// its subgroups, as with the Gitlab API
+func (r *Registry) ListGroupRegistryRepositories(gid int, opt *api.ListGroupRegistryRepositoriesOptions) ([]*api.GroupRegistryRepository, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListGroupRegistryRepositories"]++
+
+	group := r.namespace(gid)
+	if group == nil {
+		return nil, response(http.StatusNotFound), notFound("group", gid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(gitlab.ListOptions(*opt))
+	}
+
+	var repositories []*api.GroupRegistryRepository
+	for _, project := range r.projects {
+		if project.Namespace.ID != group.ID && !r.isDescendant(project.Namespace.ID, group.ID) {
+			continue
+		}
+		for _, repository := range r.repositories[project.ID] {
+			repositories = append(repositories, &api.GroupRegistryRepository{
+				RegistryRepository: *repository,
+				ProjectID:          project.ID,
+			})
+		}
+	}
+
+	start, end, resp := paginate(len(repositories), page, perPage)
+	return repositories[start:end], resp, nil
+}
+
+func (r *Registry) ListRegistryRepositoryTags(pid interface{}, repository int, opt *gitlab.ListRegistryRepositoryTagsOptions) ([]*gitlab.RegistryRepositoryTag, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListRegistryRepositoryTags"]++
+
+	if !r.hasRepository(pid, repository) {
+		return nil, response(http.StatusNotFound), notFound("repository", repository)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(gitlab.ListOptions(*opt))
+	}
+
+	tags := r.tags[repository]
+	start, end, resp := paginate(len(tags), page, perPage)
+
+	// Tag listing only includes tag metadata, as with the Gitlab API
+	var tagsMeta []*gitlab.RegistryRepositoryTag
+	for _, tag := range tags[start:end] {
+		tagsMeta = append(tagsMeta, &gitlab.RegistryRepositoryTag{
+			Name:     tag.Name,
+			Path:     tag.Path,
+			Location: tag.Location,
+		})
+	}
+
+	return tagsMeta, resp, nil
+}
+
+func (r *Registry) GetRegistryRepositoryTagDetail(pid interface{}, repository int, tagName string) (*gitlab.RegistryRepositoryTag, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["GetRegistryRepositoryTagDetail"]++
+
+	if r.hasRepository(pid, repository) {
+		for _, tag := range r.tags[repository] {
+			if tag.Name == tagName {
+				detail := *tag
+				return &detail, response(http.StatusOK), nil
+			}
+		}
+	}
+
+	return nil, response(http.StatusNotFound), notFound("tag", tagName)
+}
+
+func (r *Registry) DeleteRegistryRepositoryTag(pid interface{}, repository int, tagName string) (*gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["DeleteRegistryRepositoryTag"]++
+
+	if r.FailDeletes[tagName] {
+		return response(http.StatusInternalServerError), fmt.Errorf("failed to remove tag %s", tagName)
+	}
+
+	if r.hasRepository(pid, repository) {
+		for i, tag := range r.tags[repository] {
+			if tag.Name == tagName {
+				r.tags[repository] = append(r.tags[repository][:i:i], r.tags[repository][i+1:]...)
+				r.Deleted = append(r.Deleted, DeletedTag{
+					ProjectID:    r.project(pid).ID,
+					RepositoryID: repository,
+					Tag:          tagName,
+				})
+				return response(http.StatusOK), nil
+			}
+		}
+	}
+
+	return response(http.StatusNotFound), notFound("tag", tagName)
+}
+
+func (r *Registry) ListBranches(pid interface{}, opt *gitlab.ListBranchesOptions) ([]*gitlab.Branch, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListBranches"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+	}
+
+	branches := r.branches[project.ID]
+	start, end, resp := paginate(len(branches), page, perPage)
+	return branches[start:end], resp, nil
+}
+
+func (r *Registry) ListProjectMergeRequests(pid interface{}, opt *gitlab.ListProjectMergeRequestsOptions) ([]*gitlab.MergeRequest, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListProjectMergeRequests"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	state := "all"
+	var iids []int
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+		if opt.State != nil {
+			state = *opt.State
+		}
+		iids = opt.IIDs
+	}
+
+	var mergeRequests []*gitlab.MergeRequest
+	for _, mergeRequest := range r.mergeRequests[project.ID] {
+		if state != "all" && mergeRequest.State != state {
+			continue
+		}
+		if len(iids) > 0 && !intInSlice(mergeRequest.IID, iids) {
+			continue
+		}
+		mergeRequests = append(mergeRequests, mergeRequest)
+	}
+
+	start, end, resp := paginate(len(mergeRequests), page, perPage)
+	return mergeRequests[start:end], resp, nil
+}
+
+// ListEnvironments lists environments without their last deployment, as with the Gitlab API
+func (r *Registry) ListEnvironments(pid interface{}, opt *gitlab.ListEnvironmentsOptions) ([]*gitlab.Environment, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListEnvironments"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(gitlab.ListOptions(*opt))
+	}
+
+	var environments []*gitlab.Environment
+	for _, environment := range r.environments[project.ID] {
+		e := *environment
+		e.LastDeployment = nil
+		environments = append(environments, &e)
+	}
+
+	start, end, resp := paginate(len(environments), page, perPage)
+	return environments[start:end], resp, nil
+}
+
+func (r *Registry) GetEnvironment(pid interface{}, environment int) (*gitlab.Environment, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["GetEnvironment"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	for _, e := range r.environments[project.ID] {
+		if e.ID == environment {
+			return e, response(http.StatusOK), nil
+		}
+	}
+
+	return nil, response(http.StatusNotFound), notFound("environment", environment)
+}
+
+func (r *Registry) ListTags(pid interface{}, opt *gitlab.ListTagsOptions) ([]*gitlab.Tag, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListTags"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+	}
+
+	tags := r.gitTags[project.ID]
+	start, end, resp := paginate(len(tags), page, perPage)
+	return tags[start:end], resp, nil
+}
+
+func (r *Registry) ListProtectedTags(pid interface{}, opt *gitlab.ListProtectedTagsOptions) ([]*gitlab.ProtectedTag, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListProtectedTags"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(gitlab.ListOptions(*opt))
+	}
+
+	tags := r.protectedTags[project.ID]
+	start, end, resp := paginate(len(tags), page, perPage)
+	return tags[start:end], resp, nil
+}
+
+// ListTree lists all files within the Git repository, regardless of ref. Only recursive listings are
+// supported, and directories aren't included
+func (r *Registry) ListTree(pid interface{}, opt *gitlab.ListTreeOptions) ([]*gitlab.TreeNode, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["ListTree"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	page, perPage := 1, defaultPerPage
+	if opt != nil {
+		page, perPage = pageOptions(opt.ListOptions)
+	}
+
+	var paths []string
+	for filePath := range r.files[project.ID] {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+
+	var nodes []*gitlab.TreeNode
+	for _, p := range paths {
+		nodes = append(nodes, &gitlab.TreeNode{Name: path.Base(p), Path: p, Type: "blob"})
+	}
+
+	start, end, resp := paginate(len(nodes), page, perPage)
+	return nodes[start:end], resp, nil
+}
+
+// GetRawFile returns the content of file with given name, regardless of ref
+func (r *Registry) GetRawFile(pid interface{}, fileName string, opt *gitlab.GetRawFileOptions) ([]byte, *gitlab.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Requests["GetRawFile"]++
+
+	project := r.project(pid)
+	if project == nil {
+		return nil, response(http.StatusNotFound), notFound("project", pid)
+	}
+
+	content, exists := r.files[project.ID][fileName]
+	if !exists {
+		return nil, response(http.StatusNotFound), notFound("file", fileName)
+	}
+
+	return content, response(http.StatusOK), nil
+}
+
+// namespace returns namespace with ID or full path id, or nil if not found
+func (r *Registry) namespace(id interface{}) *gitlab.Namespace {
+	for _, namespace := range r.namespaces {
+		if namespace.ID == id || namespace.FullPath == id {
+			return namespace
+		}
+	}
+
+	return nil
+}
+
+// isDescendant returns true if namespace with ID namespaceID is a descendant of namespace with ID ancestorID
+func (r *Registry) isDescendant(namespaceID int, ancestorID int) bool {
+	namespace, exists := r.namespaces[namespaceID]
+	for exists && namespace.ParentID > 0 {
+		if namespace.ParentID == ancestorID {
+			return true
+		}
+		namespace, exists = r.namespaces[namespace.ParentID]
+	}
+
+	return false
+}
+
+// project returns project with ID or path pid, or nil if not found
+func (r *Registry) project(pid interface{}) *gitlab.Project {
+	for _, project := range r.projects {
+		if project.ID == pid || project.PathWithNamespace == pid {
+			return project
+		}
+	}
+
+	return nil
+}
+
+func (r *Registry) hasRepository(pid interface{}, repository int) bool {
+	project := r.project(pid)
+	if project == nil {
+		return false
+	}
+
+	for _, projectRepository := range r.repositories[project.ID] {
+		if projectRepository.ID == repository {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pageOptions(opt gitlab.ListOptions) (int, int) {
+	page, perPage := opt.Page, opt.PerPage
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+
+	return page, perPage
+}
+
+// paginate returns the slice bounds for page of a collection with total items, along with a response
+// populated with pagination values
+func paginate(total int, page int, perPage int) (int, int, *gitlab.Response) {
+	totalPages := (total + perPage - 1) / perPage
+	if totalPages < 1 {
+		totalPages = 1
+	}
+
+	start := (page - 1) * perPage
+	if start > total {
+		start = total
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+
+	resp := response(http.StatusOK)
+	resp.TotalItems = total
+	resp.TotalPages = totalPages
+	resp.ItemsPerPage = perPage
+	resp.CurrentPage = page
+
+	return start, end, resp
+}
+
+func response(statusCode int) *gitlab.Response {
+	return &gitlab.Response{
+		Response: &http.Response{
+			StatusCode: statusCode,
+		},
+	}
+}
+
+func notFound(resource string, id interface{}) error {
+	return fmt.Errorf("404 %s %v Not Found", resource, id)
+}
+
+func intInSlice(i int, slice []int) bool {
+	for _, s := range slice {
+		if s == i {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	headerRetryAfter         = "Retry-After"
+	headerRateLimitRemaining = "RateLimit-Remaining"
+	headerRateLimitReset     = "RateLimit-Reset"
+)
+
+// retryTransport is a http.RoundTripper which limits the rate of requests, and retries rate limited (429)
+// and server error (5xx) responses with exponential backoff and jitter, honouring Retry-After and
+// RateLimit-* response headers
+type retryTransport struct {
+	next       http.RoundTripper
+	limiter    *rate.Limiter
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		err := t.wait(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if attempt > 0 && req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := t.next.RoundTrip(req)
+		if err == nil {
+			t.updatePause(resp)
+		}
+
+		if !shouldRetry(resp, err) || attempt >= t.maxRetries || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		backoff := t.backoff(attempt, resp)
+		if err != nil {
+			log.Warnf("Request %s %s failed, retrying in %s: %s", req.Method, req.URL.Path, backoff, err)
+		} else {
+			log.Warnf("Request %s %s returned %d, retrying in %s", req.Method, req.URL.Path, resp.StatusCode, backoff)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// wait blocks until the rate limiter permits a request, and any pause requested by the server has elapsed
+func (t *retryTransport) wait(req *http.Request) error {
+	t.mu.Lock()
+	pause := time.Until(t.pauseUntil)
+	t.mu.Unlock()
+
+	if pause > 0 {
+		log.Debugf("Rate limit exhausted, pausing requests for %s", pause)
+		select {
+		case <-req.Context().Done():
+			return req.Context().Err()
+		case <-time.After(pause):
+		}
+	}
+
+	return t.limiter.Wait(req.Context())
+}
+
+// updatePause pauses subsequent requests until the rate limit resets, where the server reports no
+// remaining requests
+func (t *retryTransport) updatePause(resp *http.Response) {
+	if resp.Header.Get(headerRateLimitRemaining) != "0" {
+		return
+	}
+
+	reset, ok := parseRateLimitReset(resp)
+	if !ok {
+		return
+	}
+
+	t.mu.Lock()
+	if reset.After(t.pauseUntil) {
+		t.pauseUntil = reset
+	}
+	t.mu.Unlock()
+}
+
+// backoff returns the duration to wait before retrying given attempt. Durations requested by the server
+// take precedence over exponential backoff where longer. The rate limit reset is only honoured where
+// throttled, as it's sent with all responses where rate limiting is enabled
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	backoff := time.Duration(float64(t.minBackoff) * math.Pow(2, float64(attempt)))
+	if backoff > t.maxBackoff || backoff <= 0 {
+		backoff = t.maxBackoff
+	}
+
+	// Apply jitter within the upper half of the backoff, preventing retries from aligning
+	backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp); ok && wait > backoff {
+			backoff = wait
+		}
+		throttled := resp.StatusCode == http.StatusTooManyRequests || resp.Header.Get(headerRateLimitRemaining) == "0"
+		if reset, ok := parseRateLimitReset(resp); ok && throttled {
+			if wait := time.Until(reset); wait > backoff {
+				backoff = wait
+			}
+		}
+	}
+
+	return backoff
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// parseRetryAfter parses the Retry-After header, which is either delay seconds or a HTTP date
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	v := resp.Header.Get(headerRetryAfter)
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(v); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+// parseRateLimitReset parses the RateLimit-Reset header, which is a unix timestamp
+func parseRateLimitReset(resp *http.Response) (time.Time, bool) {
+	v := resp.Header.Get(headerRateLimitReset)
+	if v == "" {
+		return time.Time{}, false
+	}
+
+	reset, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || reset <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(reset, 0), true
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+)
+
+func newTestTransport(maxRetries int) *retryTransport {
+	return newRetryTransport(http.DefaultTransport, config.ClientConfig{
+		MaxRetries: &maxRetries,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+	})
+}
+
+func TestRetryTransport_RoundTrip(t *testing.T) {
+	t.Run("RetryableStatus_Retries", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if requests < 3 {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		client := &http.Client{Transport: newTestTransport(5)}
+		resp, err := client.Get(server.URL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("MaxRetriesExceeded_ReturnsLastResponse", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusBadGateway)
+		}))
+		defer server.Close()
+
+		client := &http.Client{Transport: newTestTransport(2)}
+		resp, err := client.Get(server.URL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Equal(t, 3, requests)
+	})
+
+	t.Run("NonRetryableStatus_DoesNotRetry", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer server.Close()
+
+		client := &http.Client{Transport: newTestTransport(5)}
+		resp, err := client.Get(server.URL)
+
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		assert.Equal(t, 1, requests)
+	})
+}
+
+func TestRetryTransport_Backoff(t *testing.T) {
+	t.Run("RetryAfterSeconds_HonoursHeader", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("Retry-After", "10")
+
+		backoff := newTestTransport(5).backoff(0, resp)
+
+		assert.Equal(t, 10*time.Second, backoff)
+	})
+
+	t.Run("RateLimitReset_HonoursHeader", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		resp.Header.Set("RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		backoff := newTestTransport(5).backoff(0, resp)
+
+		assert.Greater(t, int64(backoff), int64(30*time.Second))
+	})
+
+	t.Run("RateLimitExhausted_HonoursRateLimitReset", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		resp.Header.Set("RateLimit-Remaining", "0")
+		resp.Header.Set("RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		backoff := newTestTransport(5).backoff(0, resp)
+
+		assert.Greater(t, int64(backoff), int64(30*time.Second))
+	})
+
+	t.Run("ServerErrorWithQuotaRemaining_IgnoresRateLimitReset", func(t *testing.T) {
+		resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+		resp.Header.Set("RateLimit-Remaining", "100")
+		resp.Header.Set("RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		backoff := newTestTransport(5).backoff(0, resp)
+
+		assert.LessOrEqual(t, int64(backoff), int64(5*time.Millisecond))
+	})
+
+	t.Run("NoHeaders_BoundedByMaxBackoff", func(t *testing.T) {
+		backoff := newTestTransport(5).backoff(20, nil)
+
+		assert.LessOrEqual(t, int64(backoff), int64(5*time.Millisecond))
+	})
+}
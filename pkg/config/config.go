@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
@@ -10,8 +11,17 @@ import (
 type Config struct {
 	AccessToken  string             `yaml:"access_token"`
 	URL          string             `yaml:"url"`
+	Debug        bool               `yaml:"debug"`
 	Policies     []PolicyConfig     `yaml:"policies"`
 	Repositories []RepositoryConfig `yaml:"repositories"`
+	Client       ClientConfig       `yaml:"client"`
+
+	MaxDeletions       int     `yaml:"max_deletions"`
+	MaxDeletionPercent float64 `yaml:"max_deletion_percent"`
+	MaxRunDeletions    int     `yaml:"max_run_deletions"`
+
+	// InUseFiles are paths of files listing in-use images, which are never removed
+	InUseFiles []string `yaml:"in_use_files"`
 }
 
 func (c *Config) GetPolicyConfig(name string) (PolicyConfig, error) {
@@ -24,24 +34,83 @@ func (c *Config) GetPolicyConfig(name string) (PolicyConfig, error) {
 	return PolicyConfig{}, fmt.Errorf("Cannot find policy %s", name)
 }
 
+// ClientConfig specifies rate limiting and retry behaviour for Gitlab API requests
+type ClientConfig struct {
+	RequestsPerSecond float64       `yaml:"requests_per_second"`
+	Burst             int           `yaml:"burst"`
+	MaxRetries        *int          `yaml:"max_retries"`
+	MinBackoff        time.Duration `yaml:"min_backoff"`
+	MaxBackoff        time.Duration `yaml:"max_backoff"`
+}
+
 type PolicyConfig struct {
-	Name   string       `yaml:"name"`
-	Filter FilterConfig `yaml:"filter"`
+	Name               string       `yaml:"name"`
+	Filter             FilterConfig `yaml:"filter"`
+	MaxDeletions       int          `yaml:"max_deletions"`
+	MaxDeletionPercent float64      `yaml:"max_deletion_percent"`
 }
 
 type RepositoryConfig struct {
-	Project  int      `yaml:"project"`
-	Group    int      `yaml:"group"`
-	Recurse  bool     `yaml:"recurse"`
-	Images   []string `yaml:"images"`
-	Policies []string `yaml:"policies"`
+	// Project and Group accept either a numeric ID or full path, e.g. team/app
+	Project    string   `yaml:"project"`
+	Group      string   `yaml:"group"`
+	Recurse    bool     `yaml:"recurse"`
+	Images     []string `yaml:"images"`
+	Policies   []string `yaml:"policies"`
+	PolicyMode string   `yaml:"policy_mode"`
+	// Environments are glob patterns matching environment names, e.g. production/*. Tags deployed to
+	// matching environments are never removed, regardless of policies
+	Environments []string `yaml:"environments"`
+	// ScanFiles are glob patterns matching files within the project's default branch, e.g.
+	// docker-compose.yml or deploy/*.yaml. Patterns without wildcards are paths from the repository root.
+	// Tags referenced within matching files are never removed
+	ScanFiles []string `yaml:"scan_files"`
+
+	MaxDeletions       int     `yaml:"max_deletions"`
+	MaxDeletionPercent float64 `yaml:"max_deletion_percent"`
+
+	// ProjectID and GroupID are resolved from Project and Group at runtime
+	ProjectID int `yaml:"-"`
+	GroupID   int `yaml:"-"`
 }
 
 type FilterConfig struct {
-	Include string `yaml:"include"`
-	Exclude string `yaml:"exclude"`
-	Keep    int    `yaml:"keep"`
-	Age     int    `yaml:"age"`
+	Include             string   `yaml:"include"`
+	Exclude             string   `yaml:"exclude"`
+	Keep                int      `yaml:"keep"`
+	KeepPerGroup        string   `yaml:"keep_per_group"`
+	Age                 Duration `yaml:"age"`
+	MinAge              Duration `yaml:"min_age"`
+	Order               string   `yaml:"order"`
+	KeepPatchesPerMinor int      `yaml:"keep_patches_per_minor"`
+	KeepHighestPerMajor bool     `yaml:"keep_highest_per_major"`
+	KeepDaily           int      `yaml:"keep_daily"`
+	KeepWeekly          int      `yaml:"keep_weekly"`
+	KeepMonthly         int      `yaml:"keep_monthly"`
+	KeepGitTags         bool     `yaml:"keep_git_tags"`
+	KeepProtectedTags   bool     `yaml:"keep_protected_tags"`
+	// GitTagTemplate maps Git tag names to image tag names, where {{tag}} is replaced with the Git tag
+	// name, e.g. release-{{tag}}. Defaults to {{tag}}
+	GitTagTemplate string `yaml:"git_tag_template"`
+
+	DeletedBranch DeletedBranchConfig `yaml:"deleted_branch"`
+	MergeRequest  MergeRequestConfig  `yaml:"merge_request"`
+}
+
+// DeletedBranchConfig specifies tags should be selected where the branch captured from the tag name no
+// longer exists within the project
+type DeletedBranchConfig struct {
+	// Pattern is a regex with a capture group capturing the branch name or slug from tag names
+	Pattern     string   `yaml:"pattern"`
+	GracePeriod Duration `yaml:"grace_period"`
+}
+
+// MergeRequestConfig specifies tags should be selected where the merge request captured from the tag
+// name has been merged or closed, protecting tags for open merge requests
+type MergeRequestConfig struct {
+	// Pattern is a regex with a capture group capturing the merge request IID or source branch from tag names
+	Pattern     string   `yaml:"pattern"`
+	GracePeriod Duration `yaml:"grace_period"`
 }
 
 func Parse(path string) (*Config, error) {
@@ -59,3 +128,20 @@ func Parse(path string) (*Config, error) {
 
 	return config, nil
 }
+
+// ParseStrict parses config file at path, returning an error where the file contains unknown keys
+func ParseStrict(path string) (*Config, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %w", err)
+	}
+
+	config := &Config{}
+
+	err = yaml.UnmarshalStrict(bytes, config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal config file: %w", err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestParseStrict(t *testing.T) {
+	t.Run("KnownKeys_Parses", func(t *testing.T) {
+		path := writeConfig(t, `
+policies:
+- name: testpolicy
+  filter:
+    include: .*
+    keep: 5
+repositories:
+- project: 123
+  policies:
+  - testpolicy
+`)
+
+		cfg, err := ParseStrict(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 5, cfg.Policies[0].Filter.Keep)
+		assert.Equal(t, "123", cfg.Repositories[0].Project)
+	})
+
+	t.Run("AgeDurations_Parses", func(t *testing.T) {
+		path := writeConfig(t, `
+policies:
+- name: days
+  filter:
+    age: 30
+- name: hours
+  filter:
+    age: 36h
+    min_age: 2w
+`)
+
+		cfg, err := ParseStrict(path)
+
+		assert.Nil(t, err)
+		assert.Equal(t, Days(30), cfg.Policies[0].Filter.Age)
+		assert.Equal(t, Duration(36*time.Hour), cfg.Policies[1].Filter.Age)
+		assert.Equal(t, Days(14), cfg.Policies[1].Filter.MinAge)
+	})
+
+	t.Run("InvalidAge_ReturnsError", func(t *testing.T) {
+		path := writeConfig(t, `
+policies:
+- name: testpolicy
+  filter:
+    age: 2 weeks
+`)
+
+		_, err := ParseStrict(path)
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("UnknownKey_ReturnsError", func(t *testing.T) {
+		path := writeConfig(t, `
+policies:
+- name: testpolicy
+  filter:
+    includes: .*
+`)
+
+		_, err := ParseStrict(path)
+
+		assert.NotNil(t, err)
+	})
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+)
+
+// durationUnitRegexp matches day and week components of a duration string, which aren't supported by
+// time.ParseDuration
+var durationUnitRegexp = regexp.MustCompile(`([0-9]*\.?[0-9]+)([dw])`)
+
+// Duration represents a duration specified in config. Durations are specified either as an integer
+// amount of days, or as a Go duration string additionally supporting days (d) and weeks (w), e.g. 36h
+// or 2w3d
+type Duration time.Duration
+
+// Days returns a Duration of n days
+func Days(n int) Duration {
+	return Duration(time.Duration(n) * day)
+}
+
+// ParseDuration parses s as a Duration
+func ParseDuration(s string) (Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, err := strconv.Atoi(s); err == nil {
+		return Days(days), nil
+	}
+
+	var unitErr error
+	expanded := durationUnitRegexp.ReplaceAllStringFunc(s, func(component string) string {
+		match := durationUnitRegexp.FindStringSubmatch(component)
+		value, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			unitErr = err
+			return component
+		}
+
+		unit := day
+		if match[2] == "w" {
+			unit = week
+		}
+
+		return strconv.FormatFloat(value*unit.Hours(), 'f', -1, 64) + "h"
+	})
+	if unitErr != nil {
+		return 0, fmt.Errorf("Invalid duration %s: %s", s, unitErr)
+	}
+
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %s", s)
+	}
+
+	return Duration(d), nil
+}
+
+// parseDurationValue parses a Duration from a decoded config value
+func parseDurationValue(value interface{}) (Duration, error) {
+	switch v := value.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return Days(v), nil
+	case int64:
+		return Days(int(v)), nil
+	case float64:
+		return Duration(v * float64(day)), nil
+	case string:
+		return ParseDuration(v)
+	case Duration:
+		return v, nil
+	}
+
+	return 0, fmt.Errorf("Invalid duration %v", value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	err := unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := parseDurationValue(value)
+	if err != nil {
+		return err
+	}
+
+	*d = parsed
+	return nil
+}
+
+// String returns the duration formatted in days where a whole amount of days, otherwise as a Go
+// duration string
+func (d Duration) String() string {
+	if d != 0 && time.Duration(d)%day == 0 {
+		return fmt.Sprintf("%dd", time.Duration(d)/day)
+	}
+
+	return time.Duration(d).String()
+}
+
+// DurationDecodeHook is a mapstructure decode hook which decodes Duration values from config
+func DurationDecodeHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != reflect.TypeOf(Duration(0)) {
+		return data, nil
+	}
+
+	return parseDurationValue(data)
+}
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{value: "30", expected: 30 * 24 * time.Hour},
+		{value: "36h", expected: 36 * time.Hour},
+		{value: "90m", expected: 90 * time.Minute},
+		{value: "2d", expected: 48 * time.Hour},
+		{value: "2w", expected: 14 * 24 * time.Hour},
+		{value: "1w2d12h", expected: 9*24*time.Hour + 12*time.Hour},
+		{value: "1.5d", expected: 36 * time.Hour},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			d, err := ParseDuration(test.value)
+
+			assert.Nil(t, err)
+			assert.Equal(t, Duration(test.expected), d)
+		})
+	}
+
+	t.Run("InvalidDuration_ReturnsError", func(t *testing.T) {
+		_, err := ParseDuration("2 weeks")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestDuration_String(t *testing.T) {
+	assert.Equal(t, "14d", Days(14).String())
+	assert.Equal(t, "36h0m0s", Duration(36*time.Hour).String())
+	assert.Equal(t, "0s", Duration(0).String())
+}
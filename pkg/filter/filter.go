@@ -4,40 +4,148 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/inuse"
 	"github.com/xanzy/go-gitlab"
 )
 
+const (
+	// OrderCreated orders tags by creation date
+	OrderCreated = "created"
+	// OrderSemver orders tags by semantic version precedence, with non-semver tags ordered first
+	OrderSemver = "semver"
+)
+
+// GitTagPlaceholder is replaced with Git tag names within GitTagTemplate
+const GitTagPlaceholder = "{{tag}}"
+
+// shortSHALength is the length of short commit SHAs, as with Gitlab CI's CI_COMMIT_SHORT_SHA
+const shortSHALength = 8
+
 type Filter func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error)
 
+// Stage represents a named filter executed as part of a pipeline
+type Stage struct {
+	Name   string
+	Filter Filter
+}
+
+func NewStage(name string, filter Filter) Stage {
+	return Stage{
+		Name:   name,
+		Filter: filter,
+	}
+}
+
+// Decision represents how a single stage handled a tag
+type Decision struct {
+	Stage string
+	// Selected specifies whether the tag remained selected for removal after the stage
+	Selected bool
+	// Position is the 1-based position of the tag within the stage result, where selected
+	Position int
+	// Total is the amount of tags within the stage result
+	Total int
+}
+
 type FilterPipeline struct {
-	tags   []*gitlab.RegistryRepositoryTag
-	config config.FilterConfig
+	tags       []*gitlab.RegistryRepositoryTag
+	config     config.FilterConfig
+	selectedBy map[string]string
+	decisions  map[string][]Decision
 }
 
 func NewFilterPipeline(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) *FilterPipeline {
 	return &FilterPipeline{
-		tags:   tags,
-		config: config,
+		tags:       tags,
+		config:     config,
+		selectedBy: make(map[string]string),
+		decisions:  make(map[string][]Decision),
 	}
 }
 
 func (f *FilterPipeline) Execute(filters ...Filter) ([]*gitlab.RegistryRepositoryTag, error) {
+	var stages []Stage
+	for i, filter := range filters {
+		stages = append(stages, NewStage(fmt.Sprintf("Filter%d", i), filter))
+	}
+
+	return f.ExecuteStages(stages...)
+}
+
+// ExecuteStages executes provided stages in order, recording the stage which selected each resulting tag,
+// along with the decision made by each stage for every tag it was provided
+func (f *FilterPipeline) ExecuteStages(stages ...Stage) ([]*gitlab.RegistryRepositoryTag, error) {
+	selectedBy := make(map[string]string)
+	f.decisions = make(map[string][]Decision)
+
 	filteredTags := f.tags
-	for _, filter := range filters {
-		filteredTagsResult, err := filter(filteredTags, f.config)
+	for i, stage := range stages {
+		filteredTagsResult, err := stage.Filter(filteredTags, f.config)
 		if err != nil {
 			return filteredTagsResult, err
 		}
+
+		positions := make(map[string]int)
+		for i, tag := range filteredTagsResult {
+			positions[tag.Name] = i + 1
+		}
+		for _, tag := range filteredTags {
+			f.decisions[tag.Name] = append(f.decisions[tag.Name], Decision{
+				Stage:    stage.Name,
+				Selected: positions[tag.Name] > 0,
+				Position: positions[tag.Name],
+				Total:    len(filteredTagsResult),
+			})
+		}
+
+		// A tag is selected by the last stage to narrow the set of tags it survived, falling back to
+		// the first stage where no stage narrowed the set
+		if i == 0 || len(filteredTagsResult) < len(filteredTags) {
+			for _, tag := range filteredTagsResult {
+				selectedBy[tag.Name] = stage.Name
+			}
+		}
 		filteredTags = filteredTagsResult
 	}
 
+	f.selectedBy = make(map[string]string)
+	for _, tag := range filteredTags {
+		f.selectedBy[tag.Name] = selectedBy[tag.Name]
+	}
+
 	return filteredTags, nil
 }
 
+// SelectedBy returns the name of the stage which selected tag with given name, or an empty
+// string if tag wasn't returned from the pipeline
+func (f *FilterPipeline) SelectedBy(name string) string {
+	return f.selectedBy[name]
+}
+
+// KeptBy returns the name of the stage which removed tag with given name from the set of tags
+// selected for removal, or an empty string if tag was returned from the pipeline
+func (f *FilterPipeline) KeptBy(name string) string {
+	for _, decision := range f.decisions[name] {
+		if !decision.Selected {
+			return decision.Stage
+		}
+	}
+
+	return ""
+}
+
+// Decisions returns the decision made by each stage provided tag with given name, in order of
+// execution. Stages executed after the tag was removed from the set aren't included
+func (f *FilterPipeline) Decisions(name string) []Decision {
+	return f.decisions[name]
+}
+
 func IncludeFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
 	var filteredTags []*gitlab.RegistryRepositoryTag
 
@@ -81,7 +189,12 @@ func ExcludeFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterCon
 	return filteredTags, nil
 }
 
+// KeepFilter excludes the last Keep tags, or the last Keep tags of each group where KeepPerGroup is specified
 func KeepFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+	if len(config.KeepPerGroup) > 0 {
+		return keepPerGroup(tags, config)
+	}
+
 	var filteredTags []*gitlab.RegistryRepositoryTag
 
 	var notKept []*gitlab.RegistryRepositoryTag
@@ -101,17 +214,198 @@ func KeepFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig
 	return filteredTags, nil
 }
 
+// keepPerGroup excludes the last Keep tags of each group, where tags are grouped by the value captured by
+// the KeepPerGroup regex
+func keepPerGroup(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+	groups, err := tagGroups(tags, config.KeepPerGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make(map[string]bool)
+	keptCount := make(map[string]int)
+	for i := len(tags) - 1; i >= 0; i-- {
+		group := groups[tags[i].Name]
+		if keptCount[group] < config.Keep {
+			keptCount[group]++
+			kept[tags[i].Name] = true
+		}
+	}
+
+	var filteredTags []*gitlab.RegistryRepositoryTag
+	for _, tag := range tags {
+		if kept[tag.Name] {
+			continue
+		}
+
+		log.Debugf("KeepFilter: Including non-kept tag %s for group '%s'", tag.Name, groups[tag.Name])
+		filteredTags = append(filteredTags, tag)
+	}
+
+	return filteredTags, nil
+}
+
+// tagGroups returns the group of each tag keyed by tag name, which is the value captured by the first
+// capture group of regex expr. Tags not matching expr are grouped together with an empty group
+func tagGroups(tags []*gitlab.RegistryRepositoryTag, expr string) (map[string]string, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid keep_per_group regex %s: %s", expr, err)
+	}
+
+	groups := make(map[string]string)
+	for _, tag := range tags {
+		match := re.FindStringSubmatch(tag.Name)
+		if len(match) > 1 {
+			groups[tag.Name] = match[1]
+		}
+	}
+
+	return groups, nil
+}
+
+// OrderedFilter orders tags by Order, with tags additionally grouped where KeepPerGroup is specified
 func OrderedFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
 	filteredTags := tags
 
-	sort.SliceStable(filteredTags, func(i, j int) bool {
-		return filteredTags[i].CreatedAt.Before(*filteredTags[j].CreatedAt)
-	})
+	switch config.Order {
+	case "", OrderCreated:
+		sort.SliceStable(filteredTags, func(i, j int) bool {
+			return filteredTags[i].CreatedAt.Before(*filteredTags[j].CreatedAt)
+		})
+	case OrderSemver:
+		sort.SliceStable(filteredTags, func(i, j int) bool {
+			return semverLess(filteredTags[i], filteredTags[j])
+		})
+	default:
+		return nil, fmt.Errorf("Unsupported order %s", config.Order)
+	}
+
+	if len(config.KeepPerGroup) > 0 {
+		groups, err := tagGroups(filteredTags, config.KeepPerGroup)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(filteredTags, func(i, j int) bool {
+			return groups[filteredTags[i].Name] < groups[filteredTags[j].Name]
+		})
+	}
 
-	log.Debug("OrderedFilter: Ordering tags")
+	log.Debugf("OrderedFilter: Ordering tags by %s", config.Order)
 	return filteredTags, nil
 }
 
+// semverLess returns true if tag a has lower semantic version precedence than tag b. Tags which aren't
+// semantic versions are ordered before those that are, with ties ordered by creation date
+func semverLess(a *gitlab.RegistryRepositoryTag, b *gitlab.RegistryRepositoryTag) bool {
+	aVersion, aOK := parseSemver(a.Name)
+	bVersion, bOK := parseSemver(b.Name)
+
+	if aOK != bOK {
+		return bOK
+	}
+
+	if aOK {
+		if c := aVersion.compare(bVersion); c != 0 {
+			return c < 0
+		}
+	}
+
+	return a.CreatedAt.Before(*b.CreatedAt)
+}
+
+// HighestPerMajorFilter excludes the highest semantic version release of each major version
+func HighestPerMajorFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+	if !config.KeepHighestPerMajor {
+		return tags, nil
+	}
+
+	highest := make(map[int]semver)
+	for _, tag := range tags {
+		version, ok := parseSemver(tag.Name)
+		if !ok || !version.isRelease() {
+			continue
+		}
+
+		if current, exists := highest[version.major]; !exists || version.compare(current) > 0 {
+			highest[version.major] = version
+		}
+	}
+
+	var filteredTags []*gitlab.RegistryRepositoryTag
+	for _, tag := range tags {
+		version, ok := parseSemver(tag.Name)
+		if ok && version.isRelease() && version.compare(highest[version.major]) == 0 {
+			log.Debugf("HighestPerMajorFilter: Excluding highest release %s for major version %d", tag.Name, version.major)
+			continue
+		}
+
+		log.Debugf("HighestPerMajorFilter: Including tag %s", tag.Name)
+		filteredTags = append(filteredTags, tag)
+	}
+
+	return filteredTags, nil
+}
+
+// PatchesPerMinorFilter excludes the latest N semantic version patch releases of each minor version
+func PatchesPerMinorFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+	if config.KeepPatchesPerMinor < 1 {
+		return tags, nil
+	}
+
+	type minorVersion struct {
+		major int
+		minor int
+	}
+
+	patches := make(map[minorVersion][]int)
+	for _, tag := range tags {
+		version, ok := parseSemver(tag.Name)
+		if !ok || !version.isRelease() {
+			continue
+		}
+
+		key := minorVersion{major: version.major, minor: version.minor}
+		if !intInSlice(version.patch, patches[key]) {
+			patches[key] = append(patches[key], version.patch)
+		}
+	}
+
+	// Determine lowest patch version to keep for each minor version
+	lowestKept := make(map[minorVersion]int)
+	for key, minorPatches := range patches {
+		sort.Sort(sort.Reverse(sort.IntSlice(minorPatches)))
+		if len(minorPatches) > config.KeepPatchesPerMinor {
+			minorPatches = minorPatches[:config.KeepPatchesPerMinor]
+		}
+		lowestKept[key] = minorPatches[len(minorPatches)-1]
+	}
+
+	var filteredTags []*gitlab.RegistryRepositoryTag
+	for _, tag := range tags {
+		version, ok := parseSemver(tag.Name)
+		if ok && version.isRelease() && version.patch >= lowestKept[minorVersion{major: version.major, minor: version.minor}] {
+			log.Debugf("PatchesPerMinorFilter: Excluding latest patch release %s for minor version %d.%d", tag.Name, version.major, version.minor)
+			continue
+		}
+
+		log.Debugf("PatchesPerMinorFilter: Including tag %s", tag.Name)
+		filteredTags = append(filteredTags, tag)
+	}
+
+	return filteredTags, nil
+}
+
+func intInSlice(v int, slice []int) bool {
+	for _, sliceInt := range slice {
+		if v == sliceInt {
+			return true
+		}
+	}
+	return false
+}
+
 func ExcludeLatestFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
 	var filteredTags []*gitlab.RegistryRepositoryTag
 
@@ -125,15 +419,462 @@ func ExcludeLatestFilter(tags []*gitlab.RegistryRepositoryTag, config config.Fil
 	return filteredTags, nil
 }
 
+// AgeFilter includes tags created longer than Age ago
 func AgeFilter(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
-	var filteredTags []*gitlab.RegistryRepositoryTag
+	return NewAgeFilter(time.Now())(tags, config)
+}
 
-	for _, tag := range tags {
-		if config.Age < 1 || tag.CreatedAt.Before(time.Now().Add(-(time.Duration(config.Age*24) * time.Hour))) {
-			log.Debugf("ExcludeLatestFilter: Including aged tag %s", tag.Name)
+// NewAgeFilter returns a filter which includes tags created longer than Age prior to now
+func NewAgeFilter(now time.Time) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		var filteredTags []*gitlab.RegistryRepositoryTag
+
+		for _, tag := range tags {
+			if config.Age <= 0 || tag.CreatedAt.Before(now.Add(-time.Duration(config.Age))) {
+				log.Debugf("AgeFilter: Including aged tag %s", tag.Name)
+				filteredTags = append(filteredTags, tag)
+			}
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// NewRetentionFilter returns a filter which excludes the newest tag created within each of the most recent
+// KeepDaily days, KeepWeekly weeks and KeepMonthly months prior to now (grandfather-father-son retention).
+// Buckets are calendar days, ISO weeks starting Monday and calendar months, in UTC
+func NewRetentionFilter(now time.Time) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		if config.KeepDaily < 1 && config.KeepWeekly < 1 && config.KeepMonthly < 1 {
+			return tags, nil
+		}
+
+		now := now.UTC()
+		rules := []struct {
+			name     string
+			count    int
+			start    func(t time.Time) time.Time
+			earliest time.Time
+		}{
+			{"daily", config.KeepDaily, startOfDay, startOfDay(now).AddDate(0, 0, -(config.KeepDaily - 1))},
+			{"weekly", config.KeepWeekly, startOfWeek, startOfWeek(now).AddDate(0, 0, -7*(config.KeepWeekly-1))},
+			{"monthly", config.KeepMonthly, startOfMonth, startOfMonth(now).AddDate(0, -(config.KeepMonthly - 1), 0)},
+		}
+
+		kept := make(map[string]bool)
+		for _, rule := range rules {
+			if rule.count < 1 {
+				continue
+			}
+
+			newest := make(map[time.Time]*gitlab.RegistryRepositoryTag)
+			for _, tag := range tags {
+				if tag.CreatedAt == nil || tag.CreatedAt.After(now) {
+					continue
+				}
+
+				bucket := rule.start(tag.CreatedAt.UTC())
+				if bucket.Before(rule.earliest) {
+					continue
+				}
+
+				if current, exists := newest[bucket]; !exists || tag.CreatedAt.After(*current.CreatedAt) {
+					newest[bucket] = tag
+				}
+			}
+
+			for bucket, tag := range newest {
+				log.Debugf("RetentionFilter: Excluding tag %s as newest in %s bucket %s", tag.Name, rule.name, bucket.Format("2006-01-02"))
+				kept[tag.Name] = true
+			}
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if !kept[tag.Name] {
+				filteredTags = append(filteredTags, tag)
+			}
+		}
+
+		return filteredTags, nil
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// startOfWeek returns the start of the ISO week (Monday) containing t
+func startOfWeek(t time.Time) time.Time {
+	day := startOfDay(t)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// NewDeletedBranchFilter returns a filter which includes tags for branches which no longer exist, where
+// the branch is captured from tag names by DeletedBranch.Pattern and branches contains the names of all
+// existing branches. Branches are matched by either name or slug. Tags are only included once the newest
+// tag for the branch within allTags, i.e. including tags kept by earlier stages, is older than
+// DeletedBranch.GracePeriod prior to now
+func NewDeletedBranchFilter(now time.Time, branches []string, allTags []*gitlab.RegistryRepositoryTag) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		if len(config.DeletedBranch.Pattern) == 0 {
+			return tags, nil
+		}
+
+		groups, err := tagGroups(tags, config.DeletedBranch.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		existing := make(map[string]bool)
+		for _, branch := range branches {
+			existing[branch] = true
+			existing[branchSlug(branch)] = true
+		}
+
+		allGroups, err := tagGroups(allTags, config.DeletedBranch.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		newest := make(map[string]time.Time)
+		for _, tag := range allTags {
+			branch, matched := allGroups[tag.Name]
+			if matched && tag.CreatedAt != nil && tag.CreatedAt.After(newest[branch]) {
+				newest[branch] = *tag.CreatedAt
+			}
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			branch, matched := groups[tag.Name]
+			if !matched || len(branch) == 0 {
+				continue
+			}
+			if existing[branch] {
+				log.Debugf("DeletedBranchFilter: Excluding tag %s for existing branch %s", tag.Name, branch)
+				continue
+			}
+			if newest[branch].After(now.Add(-time.Duration(config.DeletedBranch.GracePeriod))) {
+				log.Debugf("DeletedBranchFilter: Excluding tag %s for deleted branch %s within grace period", tag.Name, branch)
+				continue
+			}
+
+			log.Debugf("DeletedBranchFilter: Including tag %s for deleted branch %s", tag.Name, branch)
 			filteredTags = append(filteredTags, tag)
 		}
+
+		return filteredTags, nil
 	}
+}
 
-	return filteredTags, nil
+// NewMergeRequestFilter returns a filter which includes tags for merge requests which have been merged or
+// closed, where the merge request IID or source branch (name or slug) is captured from tag names by
+// MergeRequest.Pattern and mergeRequests contains all merge requests for the project. Tags for open
+// merge requests, or without a known merge request, are excluded. Tags are only included once the merge
+// request was merged or closed longer than MergeRequest.GracePeriod prior to now
+func NewMergeRequestFilter(now time.Time, mergeRequests []*gitlab.MergeRequest) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		if len(config.MergeRequest.Pattern) == 0 {
+			return tags, nil
+		}
+
+		groups, err := tagGroups(tags, config.MergeRequest.Pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		byIID := make(map[string][]*gitlab.MergeRequest)
+		bySourceBranch := make(map[string][]*gitlab.MergeRequest)
+		for _, mergeRequest := range mergeRequests {
+			iid := strconv.Itoa(mergeRequest.IID)
+			byIID[iid] = append(byIID[iid], mergeRequest)
+			bySourceBranch[mergeRequest.SourceBranch] = append(bySourceBranch[mergeRequest.SourceBranch], mergeRequest)
+			if slug := branchSlug(mergeRequest.SourceBranch); slug != mergeRequest.SourceBranch {
+				bySourceBranch[slug] = append(bySourceBranch[slug], mergeRequest)
+			}
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			ref, matched := groups[tag.Name]
+			if !matched || len(ref) == 0 {
+				continue
+			}
+
+			refMergeRequests, exists := byIID[ref]
+			if !exists {
+				refMergeRequests = bySourceBranch[ref]
+			}
+			if len(refMergeRequests) == 0 {
+				log.Debugf("MergeRequestFilter: Excluding tag %s without merge request for %s", tag.Name, ref)
+				continue
+			}
+
+			finishedAt, open := mergeRequestsFinishedAt(refMergeRequests)
+			if open {
+				log.Debugf("MergeRequestFilter: Excluding tag %s for open merge request %s", tag.Name, ref)
+				continue
+			}
+			if finishedAt.After(now.Add(-time.Duration(config.MergeRequest.GracePeriod))) {
+				log.Debugf("MergeRequestFilter: Excluding tag %s for merge request %s within grace period", tag.Name, ref)
+				continue
+			}
+
+			log.Debugf("MergeRequestFilter: Including tag %s for finished merge request %s", tag.Name, ref)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// mergeRequestsFinishedAt returns the latest time any of mergeRequests were merged or closed, or true
+// if any of mergeRequests are still open
+func mergeRequestsFinishedAt(mergeRequests []*gitlab.MergeRequest) (time.Time, bool) {
+	var finishedAt time.Time
+	for _, mergeRequest := range mergeRequests {
+		var at *time.Time
+		switch mergeRequest.State {
+		case "merged":
+			at = mergeRequest.MergedAt
+		case "closed":
+			at = mergeRequest.ClosedAt
+		default:
+			return time.Time{}, true
+		}
+		if at == nil {
+			at = mergeRequest.UpdatedAt
+		}
+		if at != nil && at.After(finishedAt) {
+			finishedAt = *at
+		}
+	}
+
+	return finishedAt, false
+}
+
+// branchSlug returns the slug of branch, as with Gitlab CI's CI_COMMIT_REF_SLUG: lowercased, with
+// characters other than 0-9 and a-z replaced with -, truncated to 63 bytes and without leading or
+// trailing -
+func branchSlug(branch string) string {
+	slug := []byte(strings.ToLower(branch))
+	for i, c := range slug {
+		if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') {
+			slug[i] = '-'
+		}
+	}
+	if len(slug) > 63 {
+		slug = slug[:63]
+	}
+
+	return strings.Trim(string(slug), "-")
+}
+
+// NewMinAgeFilter returns a filter which excludes tags created within MinAge prior to now, protecting
+// fresh tags regardless of other rules
+func NewMinAgeFilter(now time.Time) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		if config.MinAge <= 0 {
+			return tags, nil
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if tag.CreatedAt.After(now.Add(-time.Duration(config.MinAge))) {
+				log.Debugf("MinAgeFilter: Excluding tag %s younger than %s", tag.Name, config.MinAge)
+				continue
+			}
+
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// NewReferencedFilter returns a filter which excludes tags referenced within repository with given path,
+// e.g. by files within the project's Git repository, by either tag name or manifest digest, regardless of
+// config
+func NewReferencedFilter(referenced *inuse.Set, path string) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if referenced.Contains(path, tag.Name, tag.Digest) {
+				log.Infof("ReferencedFilter: Excluding referenced tag %s", tag.Name)
+				continue
+			}
+
+			log.Debugf("ReferencedFilter: Including tag %s not referenced", tag.Name)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// NewEnvironmentFilter returns a filter which excludes tags deployed by the last deployment of any of
+// environments, regardless of config. Tags are deployed where named after the deployed commit SHA (or
+// containing its short SHA), or the deployed ref name or slug
+func NewEnvironmentFilter(environments []*gitlab.Environment) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if environment := deployedEnvironment(tag, environments); environment != nil {
+				log.Infof("EnvironmentFilter: Excluding tag %s deployed to environment %s", tag.Name, environment.Name)
+				continue
+			}
+
+			log.Debugf("EnvironmentFilter: Including tag %s not deployed to any environment", tag.Name)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// deployedEnvironment returns the first of environments with tag deployed by its last deployment, or nil
+// if tag isn't deployed to any of environments
+func deployedEnvironment(tag *gitlab.RegistryRepositoryTag, environments []*gitlab.Environment) *gitlab.Environment {
+	for _, environment := range environments {
+		deployment := environment.LastDeployment
+		if deployment == nil {
+			continue
+		}
+
+		if len(deployment.SHA) > 0 {
+			if tag.Name == deployment.SHA {
+				return environment
+			}
+			if len(deployment.SHA) >= shortSHALength && strings.Contains(tag.Name, deployment.SHA[:shortSHALength]) {
+				return environment
+			}
+		}
+		if len(deployment.Ref) > 0 && (tag.Name == deployment.Ref || tag.Name == branchSlug(deployment.Ref)) {
+			return environment
+		}
+	}
+
+	return nil
+}
+
+// NewGitTagFilter returns a filter which excludes tags corresponding to Git tags within the project where
+// KeepGitTags is specified, or to protected tag names or wildcards where KeepProtectedTags is specified.
+// Git tag names are mapped to image tag names via GitTagTemplate
+func NewGitTagFilter(gitTags []string, protectedTags []string) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		if !config.KeepGitTags && !config.KeepProtectedTags {
+			return tags, nil
+		}
+
+		template := config.GitTagTemplate
+		if len(template) == 0 {
+			template = GitTagPlaceholder
+		}
+
+		keep := make(map[string]bool)
+		if config.KeepGitTags {
+			for _, gitTag := range gitTags {
+				keep[strings.Replace(template, GitTagPlaceholder, gitTag, -1)] = true
+			}
+		}
+
+		var protectedRegexps []*regexp.Regexp
+		if config.KeepProtectedTags {
+			for _, protectedTag := range protectedTags {
+				// Protected tags may contain * wildcards, matching any characters
+				expr := regexp.QuoteMeta(strings.Replace(template, GitTagPlaceholder, protectedTag, -1))
+				re, err := regexp.Compile("^" + strings.Replace(expr, `\*`, ".*", -1) + "$")
+				if err != nil {
+					return nil, fmt.Errorf("Failed to compile protected tag %s: %w", protectedTag, err)
+				}
+				protectedRegexps = append(protectedRegexps, re)
+			}
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if keep[tag.Name] {
+				log.Infof("GitTagFilter: Excluding tag %s corresponding to Git tag", tag.Name)
+				continue
+			}
+			if matchesAny(tag.Name, protectedRegexps) {
+				log.Infof("GitTagFilter: Excluding tag %s corresponding to protected tag", tag.Name)
+				continue
+			}
+
+			log.Debugf("GitTagFilter: Including tag %s not corresponding to any Git tag", tag.Name)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// matchesAny returns true if s matches any of regexps
+func matchesAny(s string, regexps []*regexp.Regexp) bool {
+	for _, re := range regexps {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewInUseFilter returns a filter which excludes tags in use within repository with given path, by either
+// tag name or manifest digest, regardless of config
+func NewInUseFilter(inUse *inuse.Set, path string) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if inUse.Contains(path, tag.Name, tag.Digest) {
+				log.Infof("InUseFilter: Excluding in-use tag %s", tag.Name)
+				continue
+			}
+
+			log.Debugf("InUseFilter: Including tag %s not in use", tag.Name)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
+}
+
+// NewSharedDigestFilter returns a filter which excludes tags sharing a manifest digest with any tag in
+// tags which isn't passed to the filter, i.e. a tag which is being kept
+func NewSharedDigestFilter(allTags []*gitlab.RegistryRepositoryTag) Filter {
+	return func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+		candidates := make(map[string]bool)
+		for _, tag := range tags {
+			candidates[tag.Name] = true
+		}
+
+		keptDigests := make(map[string]string)
+		for _, tag := range allTags {
+			if candidates[tag.Name] || len(tag.Digest) == 0 {
+				continue
+			}
+			if _, exists := keptDigests[tag.Digest]; !exists {
+				keptDigests[tag.Digest] = tag.Name
+			}
+		}
+
+		var filteredTags []*gitlab.RegistryRepositoryTag
+		for _, tag := range tags {
+			if keptTag, shared := keptDigests[tag.Digest]; shared && len(tag.Digest) > 0 {
+				log.Infof("SharedDigestFilter: Excluding tag %s sharing digest %s with kept tag %s", tag.Name, tag.Digest, keptTag)
+				continue
+			}
+
+			log.Debugf("SharedDigestFilter: Including tag %s with unshared digest", tag.Name)
+			filteredTags = append(filteredTags, tag)
+		}
+
+		return filteredTags, nil
+	}
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/inuse"
 	"github.com/xanzy/go-gitlab"
 )
 
@@ -40,6 +41,91 @@ func TestFilterPipeline_Execute(t *testing.T) {
 	})
 }
 
+func TestFilterPipeline_ExecuteStages(t *testing.T) {
+	t.Run("NarrowingStage_RecordsSelectedBy", func(t *testing.T) {
+		passthrough := func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+			return tags, nil
+		}
+		dropFirst := func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+			return tags[1:], nil
+		}
+
+		p := NewFilterPipeline([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "test1",
+			},
+			{
+				Name: "test12",
+			},
+		}, config.FilterConfig{})
+		result, err := p.ExecuteStages(
+			NewStage("First", passthrough),
+			NewStage("Narrowing", dropFirst),
+			NewStage("Last", passthrough),
+		)
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "Narrowing", p.SelectedBy("test12"))
+		assert.Equal(t, "", p.SelectedBy("test1"))
+		assert.Equal(t, "Narrowing", p.KeptBy("test1"))
+		assert.Equal(t, "", p.KeptBy("test12"))
+	})
+
+	t.Run("NarrowingStage_RecordsDecisions", func(t *testing.T) {
+		passthrough := func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+			return tags, nil
+		}
+		dropFirst := func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+			return tags[1:], nil
+		}
+
+		p := NewFilterPipeline([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "test1",
+			},
+			{
+				Name: "test12",
+			},
+		}, config.FilterConfig{})
+		_, err := p.ExecuteStages(
+			NewStage("First", passthrough),
+			NewStage("Narrowing", dropFirst),
+			NewStage("Last", passthrough),
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []Decision{
+			{Stage: "First", Selected: true, Position: 1, Total: 2},
+			{Stage: "Narrowing", Selected: false, Position: 0, Total: 1},
+		}, p.Decisions("test1"))
+		assert.Equal(t, []Decision{
+			{Stage: "First", Selected: true, Position: 2, Total: 2},
+			{Stage: "Narrowing", Selected: true, Position: 1, Total: 1},
+			{Stage: "Last", Selected: true, Position: 1, Total: 1},
+		}, p.Decisions("test12"))
+	})
+
+	t.Run("NoNarrowingStage_RecordsFirstStage", func(t *testing.T) {
+		passthrough := func(tags []*gitlab.RegistryRepositoryTag, config config.FilterConfig) ([]*gitlab.RegistryRepositoryTag, error) {
+			return tags, nil
+		}
+
+		p := NewFilterPipeline([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "test1",
+			},
+		}, config.FilterConfig{})
+		_, err := p.ExecuteStages(
+			NewStage("First", passthrough),
+			NewStage("Last", passthrough),
+		)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "First", p.SelectedBy("test1"))
+	})
+}
+
 func TestIncludeFilter(t *testing.T) {
 	t.Run("NoIncludeSpecified_IncludesNone", func(t *testing.T) {
 		result, err := IncludeFilter([]*gitlab.RegistryRepositoryTag{
@@ -233,6 +319,78 @@ func TestKeepFilter(t *testing.T) {
 	})
 }
 
+func TestKeepFilter_KeepPerGroup(t *testing.T) {
+	t.Run("KeepPerGroupSpecified_KeepsExpectedPerGroup", func(t *testing.T) {
+		result, err := KeepFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "main-00000001",
+			},
+			{
+				Name: "feature-00000002",
+			},
+			{
+				Name: "main-00000003",
+			},
+			{
+				Name: "main-00000004",
+			},
+			{
+				Name: "other",
+			},
+		}, config.FilterConfig{
+			Keep:         1,
+			KeepPerGroup: "^(.+)-[0-9a-f]{8}$",
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+		assert.Equal(t, "main-00000001", result[0].Name)
+		assert.Equal(t, "main-00000003", result[1].Name)
+	})
+
+	t.Run("InvalidKeepPerGroup_ReturnsError", func(t *testing.T) {
+		_, err := KeepFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "test1",
+			},
+		}, config.FilterConfig{
+			Keep:         1,
+			KeepPerGroup: "^(",
+		})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestOrderedFilter_KeepPerGroup(t *testing.T) {
+	t.Run("KeepPerGroupSpecified_OrdersByGroupThenCreated", func(t *testing.T) {
+		time1 := time.Now().Add(-3 * time.Hour)
+		time2 := time.Now().Add(-2 * time.Hour)
+		time3 := time.Now().Add(-1 * time.Hour)
+		result, err := OrderedFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name:      "main-00000003",
+				CreatedAt: &time3,
+			},
+			{
+				Name:      "main-00000001",
+				CreatedAt: &time1,
+			},
+			{
+				Name:      "feature-00000002",
+				CreatedAt: &time2,
+			},
+		}, config.FilterConfig{
+			KeepPerGroup: "^(.+)-[0-9a-f]{8}$",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "feature-00000002", result[0].Name)
+		assert.Equal(t, "main-00000001", result[1].Name)
+		assert.Equal(t, "main-00000003", result[2].Name)
+	})
+}
+
 func TestOrderedFilter(t *testing.T) {
 	t.Run("CreatedAtPresent_Orders", func(t *testing.T) {
 		time1 := time.Now().Add(-time.Duration(5*24) * time.Hour)
@@ -258,6 +416,138 @@ func TestOrderedFilter(t *testing.T) {
 		assert.Equal(t, result[1].Name, "test12")
 		assert.Equal(t, result[2].Name, "test123")
 	})
+
+	t.Run("SemverOrder_OrdersBySemver", func(t *testing.T) {
+		time1 := time.Now().Add(-time.Duration(5*24) * time.Hour)
+		time2 := time.Now().Add(-time.Duration(4*24) * time.Hour)
+		time3 := time.Now().Add(-time.Duration(3*24) * time.Hour)
+		time4 := time.Now().Add(-time.Duration(2*24) * time.Hour)
+		result, err := OrderedFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name:      "v1.10.0",
+				CreatedAt: &time1,
+			},
+			{
+				Name:      "v1.2.0",
+				CreatedAt: &time4,
+			},
+			{
+				Name:      "v1.10.0-rc.1",
+				CreatedAt: &time2,
+			},
+			{
+				Name:      "test1",
+				CreatedAt: &time3,
+			},
+		}, config.FilterConfig{
+			Order: OrderSemver,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, result[0].Name, "test1")
+		assert.Equal(t, result[1].Name, "v1.2.0")
+		assert.Equal(t, result[2].Name, "v1.10.0-rc.1")
+		assert.Equal(t, result[3].Name, "v1.10.0")
+	})
+
+	t.Run("UnsupportedOrder_ReturnsError", func(t *testing.T) {
+		_, err := OrderedFilter([]*gitlab.RegistryRepositoryTag{}, config.FilterConfig{
+			Order: "invalid",
+		})
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestHighestPerMajorFilter(t *testing.T) {
+	t.Run("NotEnabled_IncludesAll", func(t *testing.T) {
+		result, err := HighestPerMajorFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "v1.0.0",
+			},
+			{
+				Name: "v1.1.0",
+			},
+		}, config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("Enabled_ExcludesHighestReleasePerMajor", func(t *testing.T) {
+		result, err := HighestPerMajorFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "v1.2.0",
+			},
+			{
+				Name: "v1.10.0",
+			},
+			{
+				Name: "v2.0.0",
+			},
+			{
+				Name: "v2.1.0-rc.1",
+			},
+			{
+				Name: "test1",
+			},
+		}, config.FilterConfig{
+			KeepHighestPerMajor: true,
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+		assert.Equal(t, result[0].Name, "v1.2.0")
+		assert.Equal(t, result[1].Name, "v2.1.0-rc.1")
+		assert.Equal(t, result[2].Name, "test1")
+	})
+}
+
+func TestPatchesPerMinorFilter(t *testing.T) {
+	t.Run("NotSpecified_IncludesAll", func(t *testing.T) {
+		result, err := PatchesPerMinorFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "v1.0.0",
+			},
+			{
+				Name: "v1.0.1",
+			},
+		}, config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("Specified_ExcludesLatestPatchesPerMinor", func(t *testing.T) {
+		result, err := PatchesPerMinorFilter([]*gitlab.RegistryRepositoryTag{
+			{
+				Name: "v1.0.0",
+			},
+			{
+				Name: "v1.0.1",
+			},
+			{
+				Name: "v1.0.2",
+			},
+			{
+				Name: "v1.1.0",
+			},
+			{
+				Name: "v1.1.1-rc.1",
+			},
+			{
+				Name: "test1",
+			},
+		}, config.FilterConfig{
+			KeepPatchesPerMinor: 2,
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+		assert.Equal(t, result[0].Name, "v1.0.0")
+		assert.Equal(t, result[1].Name, "v1.1.1-rc.1")
+		assert.Equal(t, result[2].Name, "test1")
+	})
 }
 
 func TestExcludeLatestFilter(t *testing.T) {
@@ -317,7 +607,7 @@ func TestAgeFilter(t *testing.T) {
 				CreatedAt: &time123,
 			},
 		}, config.FilterConfig{
-			Age: 4,
+			Age: config.Days(4),
 		})
 
 		assert.Nil(t, err)
@@ -344,10 +634,505 @@ func TestAgeFilter(t *testing.T) {
 				CreatedAt: &time123,
 			},
 		}, config.FilterConfig{
-			Age: 7,
+			Age: config.Days(7),
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 0)
+	})
+}
+
+func TestNewAgeFilter(t *testing.T) {
+	t.Run("HourAgeWithNowSpecified_ExcludesExpected", func(t *testing.T) {
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		time1 := now.Add(-48 * time.Hour)
+		time12 := now.Add(-24 * time.Hour)
+		result, err := NewAgeFilter(now)([]*gitlab.RegistryRepositoryTag{
+			{
+				Name:      "test1",
+				CreatedAt: &time1,
+			},
+			{
+				Name:      "test12",
+				CreatedAt: &time12,
+			},
+		}, config.FilterConfig{
+			Age: config.Duration(36 * time.Hour),
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test1", result[0].Name)
+	})
+}
+
+func TestNewRetentionFilter(t *testing.T) {
+	now := time.Date(2020, 6, 17, 12, 0, 0, 0, time.UTC)
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		var tags []*gitlab.RegistryRepositoryTag
+		for _, tag := range []struct {
+			name      string
+			createdAt time.Time
+		}{
+			{"test1", time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)},
+			{"test2", time.Date(2020, 5, 20, 0, 0, 0, 0, time.UTC)},
+			{"test3", time.Date(2020, 6, 9, 0, 0, 0, 0, time.UTC)},
+			{"test4", time.Date(2020, 6, 16, 10, 0, 0, 0, time.UTC)},
+			{"test5", time.Date(2020, 6, 17, 8, 0, 0, 0, time.UTC)},
+			{"test6", time.Date(2020, 6, 17, 10, 0, 0, 0, time.UTC)},
+		} {
+			createdAt := tag.createdAt
+			tags = append(tags, &gitlab.RegistryRepositoryTag{Name: tag.name, CreatedAt: &createdAt})
+		}
+		return tags
+	}
+	names := func(tags []*gitlab.RegistryRepositoryTag) []string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return names
+	}
+
+	t.Run("NoRetentionSpecified_IncludesAll", func(t *testing.T) {
+		result, err := NewRetentionFilter(now)(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 6)
+	})
+
+	t.Run("DailyRetention_ExcludesNewestPerDay", func(t *testing.T) {
+		result, err := NewRetentionFilter(now)(newTags(), config.FilterConfig{
+			KeepDaily: 2,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test1", "test2", "test3", "test5"}, names(result))
+	})
+
+	t.Run("AllBucketsSpecified_ExcludesNewestPerBucket", func(t *testing.T) {
+		result, err := NewRetentionFilter(now)(newTags(), config.FilterConfig{
+			KeepDaily:   2,
+			KeepWeekly:  2,
+			KeepMonthly: 2,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test1", "test5"}, names(result))
+	})
+}
+
+func TestNewDeletedBranchFilter(t *testing.T) {
+	now := time.Date(2020, 6, 17, 12, 0, 0, 0, time.UTC)
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		var tags []*gitlab.RegistryRepositoryTag
+		for _, tag := range []struct {
+			name      string
+			createdAt time.Time
+		}{
+			{"main-00000001", now.Add(-10 * 24 * time.Hour)},
+			{"feature-login-00000002", now.Add(-5 * 24 * time.Hour)},
+			{"feature-login-00000003", now.Add(-4 * 24 * time.Hour)},
+			{"fix-00000004", now.Add(-time.Hour)},
+			{"v1.0.0", now.Add(-10 * 24 * time.Hour)},
+		} {
+			createdAt := tag.createdAt
+			tags = append(tags, &gitlab.RegistryRepositoryTag{Name: tag.name, CreatedAt: &createdAt})
+		}
+		return tags
+	}
+
+	t.Run("NoPatternSpecified_IncludesAll", func(t *testing.T) {
+		result, err := NewDeletedBranchFilter(now, nil, newTags())(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 5)
+	})
+
+	t.Run("PatternSpecified_IncludesDeletedBranchTags", func(t *testing.T) {
+		result, err := NewDeletedBranchFilter(now, []string{"main"}, newTags())(newTags(), config.FilterConfig{
+			DeletedBranch: config.DeletedBranchConfig{
+				Pattern: "^(.+)-[0-9a-f]{8}$",
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+		assert.Equal(t, "feature-login-00000002", result[0].Name)
+		assert.Equal(t, "feature-login-00000003", result[1].Name)
+		assert.Equal(t, "fix-00000004", result[2].Name)
+	})
+
+	t.Run("BranchSlugExists_Excludes", func(t *testing.T) {
+		result, err := NewDeletedBranchFilter(now, []string{"main", "Feature/Login", "fix"}, newTags())(newTags(), config.FilterConfig{
+			DeletedBranch: config.DeletedBranchConfig{
+				Pattern: "^(.+)-[0-9a-f]{8}$",
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 0)
+	})
+
+	t.Run("GracePeriodSpecified_ExcludesRecentlyDeletedBranchTags", func(t *testing.T) {
+		result, err := NewDeletedBranchFilter(now, []string{"main"}, newTags())(newTags(), config.FilterConfig{
+			DeletedBranch: config.DeletedBranchConfig{
+				Pattern:     "^(.+)-[0-9a-f]{8}$",
+				GracePeriod: config.Days(2),
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+		assert.Equal(t, "feature-login-00000002", result[0].Name)
+		assert.Equal(t, "feature-login-00000003", result[1].Name)
+	})
+
+	t.Run("GracePeriodSpecified_MeasuredFromNewestTagWithinAllTags", func(t *testing.T) {
+		tags := newTags()
+
+		// feature-login-00000005 was kept by an earlier stage, so only older candidates are passed to the filter
+		result, err := NewDeletedBranchFilter(now, []string{"main"}, append(tags, &gitlab.RegistryRepositoryTag{
+			Name:      "feature-login-00000005",
+			CreatedAt: &now,
+		}))(tags[:3], config.FilterConfig{
+			DeletedBranch: config.DeletedBranchConfig{
+				Pattern:     "^(.+)-[0-9a-f]{8}$",
+				GracePeriod: config.Days(2),
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 0)
+	})
+}
+
+func TestNewMergeRequestFilter(t *testing.T) {
+	now := time.Date(2020, 6, 17, 12, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) *time.Time {
+		t := now.Add(-time.Duration(days) * 24 * time.Hour)
+		return &t
+	}
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		var tags []*gitlab.RegistryRepositoryTag
+		for _, name := range []string{"mr-1", "mr-2", "mr-3", "mr-4", "mr-feature-login", "v1.0.0"} {
+			tags = append(tags, &gitlab.RegistryRepositoryTag{Name: name, CreatedAt: daysAgo(10)})
+		}
+		return tags
+	}
+	names := func(tags []*gitlab.RegistryRepositoryTag) []string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return names
+	}
+	mergeRequests := []*gitlab.MergeRequest{
+		{IID: 1, SourceBranch: "one", State: "opened"},
+		{IID: 2, SourceBranch: "two", State: "merged", MergedAt: daysAgo(5)},
+		{IID: 3, SourceBranch: "three", State: "closed", ClosedAt: daysAgo(1)},
+		{IID: 5, SourceBranch: "Feature/Login", State: "merged", MergedAt: daysAgo(3)},
+	}
+
+	t.Run("NoPatternSpecified_IncludesAll", func(t *testing.T) {
+		result, err := NewMergeRequestFilter(now, nil)(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 6)
+	})
+
+	t.Run("IIDPatternSpecified_IncludesFinishedMergeRequestTags", func(t *testing.T) {
+		result, err := NewMergeRequestFilter(now, mergeRequests)(newTags(), config.FilterConfig{
+			MergeRequest: config.MergeRequestConfig{
+				Pattern: "^mr-([0-9]+)$",
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"mr-2", "mr-3"}, names(result))
+	})
+
+	t.Run("SourceBranchPatternSpecified_MatchesBranchSlug", func(t *testing.T) {
+		result, err := NewMergeRequestFilter(now, mergeRequests)(newTags(), config.FilterConfig{
+			MergeRequest: config.MergeRequestConfig{
+				Pattern: "^mr-([a-z-]+)$",
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"mr-feature-login"}, names(result))
+	})
+
+	t.Run("OpenMergeRequestForBranch_Excludes", func(t *testing.T) {
+		result, err := NewMergeRequestFilter(now, append(mergeRequests, &gitlab.MergeRequest{
+			IID: 6, SourceBranch: "feature/login", State: "opened",
+		}))(newTags(), config.FilterConfig{
+			MergeRequest: config.MergeRequestConfig{
+				Pattern: "^mr-([a-z-]+)$",
+			},
 		})
 
 		assert.Nil(t, err)
 		assert.Len(t, result, 0)
 	})
+
+	t.Run("GracePeriodSpecified_ExcludesRecentlyFinishedMergeRequestTags", func(t *testing.T) {
+		result, err := NewMergeRequestFilter(now, mergeRequests)(newTags(), config.FilterConfig{
+			MergeRequest: config.MergeRequestConfig{
+				Pattern:     "^mr-([0-9]+)$",
+				GracePeriod: config.Days(2),
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"mr-2"}, names(result))
+	})
+}
+
+func TestNewMinAgeFilter(t *testing.T) {
+	t.Run("MinAgeSpecified_ExcludesFreshTags", func(t *testing.T) {
+		now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+		time1 := now.Add(-48 * time.Hour)
+		time12 := now.Add(-time.Hour)
+		result, err := NewMinAgeFilter(now)([]*gitlab.RegistryRepositoryTag{
+			{
+				Name:      "test1",
+				CreatedAt: &time1,
+			},
+			{
+				Name:      "test12",
+				CreatedAt: &time12,
+			},
+		}, config.FilterConfig{
+			MinAge: config.Duration(6 * time.Hour),
+		})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test1", result[0].Name)
+	})
+
+	t.Run("NoMinAgeSpecified_IncludesAll", func(t *testing.T) {
+		now := time.Now()
+		result, err := NewMinAgeFilter(now)([]*gitlab.RegistryRepositoryTag{
+			{
+				Name:      "test1",
+				CreatedAt: &now,
+			},
+		}, config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+	})
+}
+
+func TestNewEnvironmentFilter(t *testing.T) {
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		var tags []*gitlab.RegistryRepositoryTag
+		for _, name := range []string{
+			"0123456789abcdef0123456789abcdef01234567",
+			"main-fedcba98",
+			"feature-login",
+			"v1.2.0",
+			"test1",
+		} {
+			tags = append(tags, &gitlab.RegistryRepositoryTag{Name: name})
+		}
+		return tags
+	}
+	environments := []*gitlab.Environment{
+		{Name: "production", LastDeployment: &gitlab.Deployment{Ref: "v1.2.0", SHA: "0123456789abcdef0123456789abcdef01234567"}},
+		{Name: "staging", LastDeployment: &gitlab.Deployment{Ref: "main", SHA: "fedcba9876543210fedcba9876543210fedcba98"}},
+		{Name: "review/feature-login", LastDeployment: &gitlab.Deployment{Ref: "Feature/Login", SHA: "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}},
+		{Name: "review/none"},
+	}
+
+	t.Run("NoEnvironments_IncludesAll", func(t *testing.T) {
+		result, err := NewEnvironmentFilter(nil)(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 5)
+	})
+
+	t.Run("Environments_ExcludesDeployedTags", func(t *testing.T) {
+		result, err := NewEnvironmentFilter(environments)(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test1", result[0].Name)
+	})
+}
+
+func TestNewGitTagFilter(t *testing.T) {
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		var tags []*gitlab.RegistryRepositoryTag
+		for _, name := range []string{"v3.4.0", "v3.5.0", "release-v3.4.0", "release-v3.5.0", "stable-1", "test1"} {
+			tags = append(tags, &gitlab.RegistryRepositoryTag{Name: name})
+		}
+		return tags
+	}
+	names := func(tags []*gitlab.RegistryRepositoryTag) []string {
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tag.Name)
+		}
+		return names
+	}
+	gitTags := []string{"v3.4.0"}
+	protectedTags := []string{"stable-*"}
+
+	t.Run("NotSpecified_IncludesAll", func(t *testing.T) {
+		result, err := NewGitTagFilter(gitTags, protectedTags)(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 6)
+	})
+
+	t.Run("KeepGitTags_ExcludesGitTags", func(t *testing.T) {
+		result, err := NewGitTagFilter(gitTags, protectedTags)(newTags(), config.FilterConfig{
+			KeepGitTags: true,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"v3.5.0", "release-v3.4.0", "release-v3.5.0", "stable-1", "test1"}, names(result))
+	})
+
+	t.Run("GitTagTemplateSpecified_ExcludesTemplatedGitTags", func(t *testing.T) {
+		result, err := NewGitTagFilter(gitTags, protectedTags)(newTags(), config.FilterConfig{
+			KeepGitTags:    true,
+			GitTagTemplate: "release-{{tag}}",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"v3.4.0", "v3.5.0", "release-v3.5.0", "stable-1", "test1"}, names(result))
+	})
+
+	t.Run("KeepProtectedTags_ExcludesWildcardMatches", func(t *testing.T) {
+		result, err := NewGitTagFilter(gitTags, []string{"stable-*", "v3.5.0"})(newTags(), config.FilterConfig{
+			KeepProtectedTags: true,
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"v3.4.0", "release-v3.4.0", "release-v3.5.0", "test1"}, names(result))
+	})
+}
+
+func TestNewInUseFilter(t *testing.T) {
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		return []*gitlab.RegistryRepositoryTag{
+			{Name: "test1", Digest: "sha256:1"},
+			{Name: "test2", Digest: "sha256:2"},
+			{Name: "test3", Digest: "sha256:3"},
+		}
+	}
+	inUse := inuse.NewSet([]inuse.Image{
+		{Path: "team/app", Tag: "test1"},
+		{Path: "team/app", Digest: "sha256:3"},
+		{Path: "team/other", Tag: "test2"},
+	})
+
+	t.Run("NoInUse_IncludesAll", func(t *testing.T) {
+		result, err := NewInUseFilter(nil, "team/app")(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+	})
+
+	t.Run("InUse_ExcludesInUseTagsAndDigests", func(t *testing.T) {
+		result, err := NewInUseFilter(inUse, "team/app")(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test2", result[0].Name)
+	})
+}
+
+func TestNewReferencedFilter(t *testing.T) {
+	newTags := func() []*gitlab.RegistryRepositoryTag {
+		return []*gitlab.RegistryRepositoryTag{
+			{Name: "test1", Digest: "sha256:1"},
+			{Name: "test2", Digest: "sha256:2"},
+		}
+	}
+
+	t.Run("NoReferences_IncludesAll", func(t *testing.T) {
+		result, err := NewReferencedFilter(nil, "team/app")(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("References_ExcludesReferencedTags", func(t *testing.T) {
+		result, err := NewReferencedFilter(inuse.NewSet([]inuse.Image{{Path: "team/app", Tag: "test1"}}), "team/app")(newTags(), config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test2", result[0].Name)
+	})
+}
+
+func TestNewSharedDigestFilter(t *testing.T) {
+	t.Run("DigestSharedWithKeptTag_Excludes", func(t *testing.T) {
+		allTags := []*gitlab.RegistryRepositoryTag{
+			{
+				Name:   "stable",
+				Digest: "sha256:a",
+			},
+			{
+				Name:   "v2.3.1",
+				Digest: "sha256:a",
+			},
+			{
+				Name:   "v2.3.0",
+				Digest: "sha256:b",
+			},
+		}
+
+		f := NewSharedDigestFilter(allTags)
+		result, err := f(allTags[1:], config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, result[0].Name, "v2.3.0")
+	})
+
+	t.Run("DigestSharedWithFilteredTag_Includes", func(t *testing.T) {
+		allTags := []*gitlab.RegistryRepositoryTag{
+			{
+				Name:   "stable",
+				Digest: "sha256:a",
+			},
+			{
+				Name:   "v2.3.1",
+				Digest: "sha256:b",
+			},
+			{
+				Name:   "v2.3.1-build",
+				Digest: "sha256:b",
+			},
+		}
+
+		f := NewSharedDigestFilter(allTags)
+		result, err := f(allTags[1:], config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 2)
+	})
+
+	t.Run("EmptyDigest_Includes", func(t *testing.T) {
+		allTags := []*gitlab.RegistryRepositoryTag{
+			{
+				Name: "stable",
+			},
+			{
+				Name: "v2.3.1",
+			},
+		}
+
+		f := NewSharedDigestFilter(allTags)
+		result, err := f(allTags[1:], config.FilterConfig{})
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+	})
 }
@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?(?:\+[0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*)?$`)
+
+// semver represents a parsed semantic version tag, e.g. v1.2.3-rc.1
+type semver struct {
+	major      int
+	minor      int
+	patch      int
+	prerelease []string
+}
+
+// parseSemver parses tag as a semantic version, with optional 'v' prefix. Returns false
+// if tag isn't a valid semantic version
+func parseSemver(tag string) (semver, bool) {
+	matches := semverRegexp.FindStringSubmatch(tag)
+	if matches == nil {
+		return semver{}, false
+	}
+
+	var v semver
+	var err error
+	if v.major, err = strconv.Atoi(matches[1]); err != nil {
+		return semver{}, false
+	}
+	if v.minor, err = strconv.Atoi(matches[2]); err != nil {
+		return semver{}, false
+	}
+	if v.patch, err = strconv.Atoi(matches[3]); err != nil {
+		return semver{}, false
+	}
+	if len(matches[4]) > 0 {
+		v.prerelease = strings.Split(matches[4], ".")
+	}
+
+	return v, true
+}
+
+// isRelease returns true if version isn't a pre-release
+func (v semver) isRelease() bool {
+	return len(v.prerelease) == 0
+}
+
+// compare returns -1, 0 or 1 if v has lower, equal or higher precedence than o
+func (v semver) compare(o semver) int {
+	if c := compareInt(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.patch, o.patch); c != 0 {
+		return c
+	}
+
+	// A release has higher precedence than any of its pre-releases
+	if v.isRelease() || o.isRelease() {
+		if v.isRelease() && o.isRelease() {
+			return 0
+		}
+		if v.isRelease() {
+			return 1
+		}
+		return -1
+	}
+
+	for i := 0; i < len(v.prerelease) && i < len(o.prerelease); i++ {
+		if c := comparePrereleaseIdentifier(v.prerelease[i], o.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+
+	return compareInt(len(v.prerelease), len(o.prerelease))
+}
+
+func comparePrereleaseIdentifier(a, b string) int {
+	aInt, aErr := strconv.Atoi(a)
+	bInt, bErr := strconv.Atoi(b)
+
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInt(aInt, bInt)
+	case aErr == nil:
+		// Numeric identifiers have lower precedence than alphanumeric identifiers
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSemver(t *testing.T) {
+	t.Run("ValidVersion_Parses", func(t *testing.T) {
+		v, ok := parseSemver("v1.2.3-rc.1")
+
+		assert.True(t, ok)
+		assert.Equal(t, 1, v.major)
+		assert.Equal(t, 2, v.minor)
+		assert.Equal(t, 3, v.patch)
+		assert.Equal(t, []string{"rc", "1"}, v.prerelease)
+	})
+
+	t.Run("InvalidVersion_ReturnsFalse", func(t *testing.T) {
+		_, ok := parseSemver("1.2")
+
+		assert.False(t, ok)
+	})
+}
+
+func TestSemver_Compare(t *testing.T) {
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+		"1.2.0",
+		"1.10.0",
+		"2.0.0",
+	}
+
+	for i := 0; i < len(ordered)-1; i++ {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+
+		assert.Equal(t, -1, a.compare(b), "%s < %s", ordered[i], ordered[i+1])
+		assert.Equal(t, 1, b.compare(a), "%s > %s", ordered[i+1], ordered[i])
+	}
+}
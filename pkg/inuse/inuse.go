@@ -0,0 +1,261 @@
+package inuse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// Image represents a normalised image reference
+type Image struct {
+	// Path is the repository path without registry host, e.g. team/app/image
+	Path   string
+	Tag    string
+	Digest string
+}
+
+func (i Image) String() string {
+	s := i.Path
+	if len(i.Tag) > 0 {
+		s += ":" + i.Tag
+	}
+	if len(i.Digest) > 0 {
+		s += "@" + i.Digest
+	}
+
+	return s
+}
+
+// imageKeys are the keys of JSON values containing image references, e.g. within Kubernetes pod specs
+// (image) and container statuses (imageID)
+var imageKeys = map[string]bool{
+	"image":   true,
+	"imageID": true,
+}
+
+// ParseReference parses image reference ref, e.g. registry.example.com/team/app/image:1.0.0 or
+// registry.example.com/team/app/image@sha256:..., into an Image. The registry host and any scheme (e.g.
+// docker-pullable://) are removed. References without a tag or digest refer to the latest tag
+func ParseReference(ref string) (Image, error) {
+	ref = strings.TrimSpace(ref)
+	if i := strings.Index(ref, "://"); i >= 0 {
+		ref = ref[i+3:]
+	}
+	if len(ref) == 0 || strings.ContainsAny(ref, " \t") || strings.HasPrefix(ref, "sha256:") {
+		return Image{}, fmt.Errorf("Invalid image reference %s", ref)
+	}
+
+	var image Image
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, image.Digest = name[:i], name[i+1:]
+	}
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, image.Tag = name[:i], name[i+1:]
+	}
+
+	// The first path component is a registry host where containing a . or : or being localhost
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			name = name[i+1:]
+		}
+	}
+
+	if len(name) == 0 {
+		return Image{}, fmt.Errorf("Invalid image reference %s", ref)
+	}
+	if len(image.Tag) == 0 && len(image.Digest) == 0 {
+		image.Tag = "latest"
+	}
+	image.Path = name
+
+	return image, nil
+}
+
+// Parse parses image references from r. Input is either JSON, e.g. output of kubectl get pods -o json,
+// where references are read from all image and imageID values, or plain text containing whitespace
+// separated references, where # starts a comment
+func Parse(r io.Reader) ([]Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		return parseJSON(trimmed)
+	}
+
+	return parseText(data)
+}
+
+// ParseFile parses image references from file at path
+func ParseFile(path string) ([]Image, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read in-use file %s: %w", path, err)
+	}
+
+	images, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse in-use file %s: %w", path, err)
+	}
+
+	return images, nil
+}
+
+func parseJSON(data []byte) ([]Image, error) {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %w", err)
+	}
+
+	var images []Image
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				if ref, ok := child.(string); ok && imageKeys[key] {
+					// Values such as local image IDs aren't references, so are ignored
+					if image, err := ParseReference(ref); err == nil {
+						images = append(images, image)
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		}
+	}
+	walk(value)
+
+	return images, nil
+}
+
+func parseText(data []byte) ([]Image, error) {
+	var images []Image
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = text[:i]
+		}
+
+		for _, ref := range strings.Fields(text) {
+			image, err := ParseReference(ref)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			images = append(images, image)
+		}
+	}
+
+	return images, scanner.Err()
+}
+
+// Extractor extracts references to images within a single registry from arbitrary content
+type Extractor struct {
+	re *regexp.Regexp
+}
+
+// NewExtractor returns an Extractor for images within registry with given host
+func NewExtractor(host string) *Extractor {
+	return &Extractor{
+		re: regexp.MustCompile(`(?:^|[^\w.-])(` + regexp.QuoteMeta(host) + `/[a-z0-9._/-]*[a-z0-9](?::\w[\w.-]{0,127})?(?:@sha256:[0-9a-f]{64})?)`),
+	}
+}
+
+// Extract extracts image references from data, e.g. the content of a docker-compose.yml or Helm values
+// file. References with templated tags, e.g. host/app:${TAG}, are ignored as the tag can't be determined
+func (e *Extractor) Extract(data []byte) []Image {
+	var images []Image
+	for _, match := range e.re.FindAllSubmatchIndex(data, -1) {
+		end := match[3]
+		if end < len(data) && strings.ContainsRune(":@${", rune(data[end])) {
+			continue
+		}
+
+		image, err := ParseReference(string(data[match[2]:end]))
+		if err != nil {
+			continue
+		}
+		images = append(images, image)
+	}
+
+	return images
+}
+
+// Set represents a set of in-use images, indexed by repository path
+type Set struct {
+	tags    map[string]map[string]bool
+	digests map[string]map[string]bool
+}
+
+func NewSet(images []Image) *Set {
+	s := &Set{
+		tags:    make(map[string]map[string]bool),
+		digests: make(map[string]map[string]bool),
+	}
+	for _, image := range images {
+		s.Add(image)
+	}
+
+	return s
+}
+
+// Add adds image to the set
+func (s *Set) Add(image Image) {
+	if len(image.Tag) > 0 {
+		if s.tags[image.Path] == nil {
+			s.tags[image.Path] = make(map[string]bool)
+		}
+		s.tags[image.Path][image.Tag] = true
+	}
+	if len(image.Digest) > 0 {
+		if s.digests[image.Path] == nil {
+			s.digests[image.Path] = make(map[string]bool)
+		}
+		s.digests[image.Path][image.Digest] = true
+	}
+}
+
+// Contains returns true if a tag named tag with manifest digest within repository with path is in use,
+// by either tag name or digest. A nil Set contains no images
+func (s *Set) Contains(path string, tag string, digest string) bool {
+	if s == nil {
+		return false
+	}
+
+	return s.tags[path][tag] || (len(digest) > 0 && s.digests[path][digest])
+}
+
+// Len returns the amount of distinct tags and digests within the set
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	n := 0
+	for _, tags := range s.tags {
+		n += len(tags)
+	}
+	for _, digests := range s.digests {
+		n += len(digests)
+	}
+
+	return n
+}
@@ -0,0 +1,154 @@
+package inuse
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReference(t *testing.T) {
+	t.Run("HostPathTag_ReturnsImage", func(t *testing.T) {
+		image, err := ParseReference("registry.example.com/team/app/image:1.0.0")
+
+		assert.Nil(t, err)
+		assert.Equal(t, Image{Path: "team/app/image", Tag: "1.0.0"}, image)
+	})
+
+	t.Run("HostWithPortDigest_ReturnsImage", func(t *testing.T) {
+		image, err := ParseReference("docker-pullable://registry.example.com:5050/team/app@sha256:abc")
+
+		assert.Nil(t, err)
+		assert.Equal(t, Image{Path: "team/app", Digest: "sha256:abc"}, image)
+	})
+
+	t.Run("TagAndDigest_ReturnsBoth", func(t *testing.T) {
+		image, err := ParseReference("localhost/team/app:v1@sha256:abc")
+
+		assert.Nil(t, err)
+		assert.Equal(t, Image{Path: "team/app", Tag: "v1", Digest: "sha256:abc"}, image)
+	})
+
+	t.Run("NoTagOrDigest_ReturnsLatest", func(t *testing.T) {
+		image, err := ParseReference("team/app")
+
+		assert.Nil(t, err)
+		assert.Equal(t, Image{Path: "team/app", Tag: "latest"}, image)
+	})
+
+	t.Run("ImageID_ReturnsError", func(t *testing.T) {
+		_, err := ParseReference("sha256:abc")
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Run("Text_ReturnsImages", func(t *testing.T) {
+		images, err := Parse(strings.NewReader(`
+# production
+registry.example.com/team/app:v1 registry.example.com/team/worker:v2
+
+registry.example.com/team/app@sha256:abc # pinned
+`))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []Image{
+			{Path: "team/app", Tag: "v1"},
+			{Path: "team/worker", Tag: "v2"},
+			{Path: "team/app", Digest: "sha256:abc"},
+		}, images)
+	})
+
+	t.Run("InvalidText_ReturnsError", func(t *testing.T) {
+		_, err := Parse(strings.NewReader("registry.example.com/team/app:v1\nsha256:abc"))
+
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "line 2")
+	})
+
+	t.Run("KubectlJSON_ReturnsImages", func(t *testing.T) {
+		images, err := Parse(strings.NewReader(`{
+  "kind": "List",
+  "items": [
+    {
+      "spec": {
+        "containers": [{"name": "app", "image": "registry.example.com/team/app:v1"}]
+      },
+      "status": {
+        "containerStatuses": [{"image": "registry.example.com/team/app:v1", "imageID": "docker-pullable://registry.example.com/team/app@sha256:abc"}]
+      }
+    }
+  ]
+}`))
+
+		assert.Nil(t, err)
+		assert.ElementsMatch(t, []Image{
+			{Path: "team/app", Tag: "v1"},
+			{Path: "team/app", Tag: "v1"},
+			{Path: "team/app", Digest: "sha256:abc"},
+		}, images)
+	})
+
+	t.Run("InvalidJSON_ReturnsError", func(t *testing.T) {
+		_, err := Parse(strings.NewReader(`{"items": [`))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestExtractor_Extract(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("a", 64)
+
+	t.Run("ComposeFile_ReturnsRegistryImages", func(t *testing.T) {
+		images := NewExtractor("registry.example.com").Extract([]byte(`
+services:
+  app:
+    image: registry.example.com/team/app:v1
+  pinned:
+    image: "registry.example.com/team/worker@` + digest + `"
+  external:
+    image: nginx:1.19
+  mirror:
+    image: mirror.registry.example.com/team/app:v2
+`))
+
+		assert.Equal(t, []Image{
+			{Path: "team/app", Tag: "v1"},
+			{Path: "team/worker", Digest: digest},
+		}, images)
+	})
+
+	t.Run("TemplatedTag_Ignored", func(t *testing.T) {
+		images := NewExtractor("registry.example.com").Extract([]byte(`image: registry.example.com/team/app:${TAG}
+image: registry.example.com/team/app:$CI_COMMIT_SHA
+script: docker pull registry.example.com/team/app`))
+
+		assert.Equal(t, []Image{{Path: "team/app", Tag: "latest"}}, images)
+	})
+}
+
+func TestSet_Contains(t *testing.T) {
+	set := NewSet([]Image{
+		{Path: "team/app", Tag: "v1"},
+		{Path: "team/app", Digest: "sha256:abc"},
+	})
+
+	t.Run("MatchingTag_ReturnsTrue", func(t *testing.T) {
+		assert.True(t, set.Contains("team/app", "v1", "sha256:def"))
+	})
+
+	t.Run("MatchingDigest_ReturnsTrue", func(t *testing.T) {
+		assert.True(t, set.Contains("team/app", "v2", "sha256:abc"))
+	})
+
+	t.Run("OtherRepository_ReturnsFalse", func(t *testing.T) {
+		assert.False(t, set.Contains("team/worker", "v1", "sha256:abc"))
+	})
+
+	t.Run("NilSet_ReturnsFalse", func(t *testing.T) {
+		var nilSet *Set
+
+		assert.False(t, nilSet.Contains("team/app", "v1", ""))
+	})
+}
@@ -0,0 +1,112 @@
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Plan represents a set of planned tag deletions, which can be reviewed prior to being applied
+type Plan struct {
+	CreatedAt time.Time  `json:"created_at"`
+	Deletions []Deletion `json:"deletions"`
+}
+
+// Deletion represents a single planned tag deletion
+type Deletion struct {
+	ProjectID    int    `json:"project_id"`
+	RepositoryID int    `json:"repository_id"`
+	Repository   string `json:"repository"`
+	Tag          string `json:"tag"`
+	Digest       string `json:"digest"`
+	Policy       string `json:"policy"`
+	Stage        string `json:"stage"`
+}
+
+func NewPlan() *Plan {
+	return &Plan{
+		CreatedAt: time.Now().UTC(),
+		Deletions: []Deletion{},
+	}
+}
+
+func (p *Plan) Add(deletions ...Deletion) {
+	p.Deletions = append(p.Deletions, deletions...)
+}
+
+// Write writes plan as JSON to file at path
+func (p *Plan) Write(path string) error {
+	bytes, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal plan: %w", err)
+	}
+
+	err = ioutil.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write plan file: %w", err)
+	}
+
+	return nil
+}
+
+// Read reads plan from JSON file at path
+func Read(path string) (*Plan, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read plan file: %w", err)
+	}
+
+	p := &Plan{}
+
+	err = json.Unmarshal(bytes, p)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal plan file: %w", err)
+	}
+
+	return p, nil
+}
+
+const (
+	// MergeAny selects tags selected by any policy
+	MergeAny = "any"
+	// MergeAll selects tags selected by every policy
+	MergeAll = "all"
+)
+
+// Merge merges deletions selected by each of multiple policies against the same repository into a
+// single set of deletions, using given mode. Merged deletions record all policies which selected
+// the tag, with the stage recorded from the first
+func Merge(mode string, policyDeletions ...[]Deletion) ([]Deletion, error) {
+	if mode != "" && mode != MergeAny && mode != MergeAll {
+		return nil, fmt.Errorf("Unsupported policy mode %s", mode)
+	}
+
+	var order []string
+	merged := make(map[string]Deletion)
+	selectedCount := make(map[string]int)
+
+	for _, deletions := range policyDeletions {
+		for _, deletion := range deletions {
+			existing, exists := merged[deletion.Tag]
+			if !exists {
+				order = append(order, deletion.Tag)
+				merged[deletion.Tag] = deletion
+			} else {
+				existing.Policy = existing.Policy + "," + deletion.Policy
+				merged[deletion.Tag] = existing
+			}
+			selectedCount[deletion.Tag]++
+		}
+	}
+
+	var result []Deletion
+	for _, tag := range order {
+		if mode == MergeAll && selectedCount[tag] < len(policyDeletions) {
+			continue
+		}
+		result = append(result, merged[tag])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,103 @@
+package plan
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan_Write(t *testing.T) {
+	t.Run("WrittenPlan_ReadsExpected", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "plan.json")
+
+		p := NewPlan()
+		p.Add(Deletion{
+			ProjectID:    1,
+			RepositoryID: 2,
+			Repository:   "group/project/app",
+			Tag:          "v1.0.0",
+			Digest:       "sha256:a",
+			Policy:       "testpolicy",
+			Stage:        "KeepFilter",
+		})
+
+		err := p.Write(path)
+		assert.Nil(t, err)
+
+		result, err := Read(path)
+
+		assert.Nil(t, err)
+		assert.Len(t, result.Deletions, 1)
+		assert.Equal(t, p.Deletions[0], result.Deletions[0])
+		assert.True(t, p.CreatedAt.Equal(result.CreatedAt))
+	})
+}
+
+func TestRead(t *testing.T) {
+	t.Run("MissingFile_ReturnsError", func(t *testing.T) {
+		_, err := Read(filepath.Join(t.TempDir(), "missing.json"))
+
+		assert.NotNil(t, err)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	policy1 := []Deletion{
+		{
+			Tag:    "test1",
+			Policy: "policy1",
+			Stage:  "KeepFilter",
+		},
+		{
+			Tag:    "test2",
+			Policy: "policy1",
+			Stage:  "KeepFilter",
+		},
+	}
+	policy2 := []Deletion{
+		{
+			Tag:    "test2",
+			Policy: "policy2",
+			Stage:  "AgeFilter",
+		},
+		{
+			Tag:    "test3",
+			Policy: "policy2",
+			Stage:  "AgeFilter",
+		},
+	}
+
+	t.Run("AnyMode_ReturnsUnion", func(t *testing.T) {
+		result, err := Merge(MergeAny, policy1, policy2)
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+		assert.Equal(t, "test1", result[0].Tag)
+		assert.Equal(t, "test2", result[1].Tag)
+		assert.Equal(t, "policy1,policy2", result[1].Policy)
+		assert.Equal(t, "KeepFilter", result[1].Stage)
+		assert.Equal(t, "test3", result[2].Tag)
+	})
+
+	t.Run("DefaultMode_ReturnsUnion", func(t *testing.T) {
+		result, err := Merge("", policy1, policy2)
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 3)
+	})
+
+	t.Run("AllMode_ReturnsIntersection", func(t *testing.T) {
+		result, err := Merge(MergeAll, policy1, policy2)
+
+		assert.Nil(t, err)
+		assert.Len(t, result, 1)
+		assert.Equal(t, "test2", result[0].Tag)
+	})
+
+	t.Run("UnsupportedMode_ReturnsError", func(t *testing.T) {
+		_, err := Merge("invalid", policy1, policy2)
+
+		assert.NotNil(t, err)
+	})
+}
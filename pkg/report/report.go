@@ -0,0 +1,213 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	// FormatJSON specifies report should be output as JSON
+	FormatJSON = "json"
+
+	// ActionKeep specifies a tag was kept by a policy
+	ActionKeep = "keep"
+	// ActionDelete specifies a tag was selected for removal by a policy
+	ActionDelete = "delete"
+)
+
+// Report represents a structured summary of a cleanup run
+type Report struct {
+	StartedAt         time.Time           `json:"started_at"`
+	FinishedAt        time.Time           `json:"finished_at"`
+	AsOf              time.Time           `json:"as_of"`
+	DryRun            bool                `json:"dry_run"`
+	RepositoryConfigs []*RepositoryConfig `json:"repository_configs"`
+	Errors            []string            `json:"errors"`
+	Totals            Totals              `json:"totals"`
+}
+
+// RepositoryConfig represents a single processed repository config
+type RepositoryConfig struct {
+	Project  string     `json:"project,omitempty"`
+	Group    string     `json:"group,omitempty"`
+	Projects []*Project `json:"projects"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// Project represents a single processed project
+type Project struct {
+	ID           int           `json:"id"`
+	Repositories []*Repository `json:"repositories"`
+}
+
+// Repository represents a single processed registry repository
+type Repository struct {
+	ID       int       `json:"id"`
+	Path     string    `json:"path"`
+	Tags     int       `json:"tags"`
+	Policies []*Policy `json:"policies"`
+	// Deleted contains tags removed (or selected for removal in dry-run/plan mode) after policies are merged
+	Deleted []string `json:"deleted"`
+	// Skipped contains the reason the repository was skipped, e.g. where deletion limits were exceeded
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Policy represents a single policy executed against a repository
+type Policy struct {
+	Name string         `json:"name"`
+	Tags []*TagDecision `json:"tags"`
+}
+
+// TagDecision represents the decision made for a single tag by a policy, along with the
+// filter stage which decided it
+type TagDecision struct {
+	Tag    string `json:"tag"`
+	Digest string `json:"digest"`
+	Action string `json:"action"`
+	Stage  string `json:"stage"`
+}
+
+// Totals represents totals across all repository configs within a run
+type Totals struct {
+	RepositoryConfigs int `json:"repository_configs"`
+	Projects          int `json:"projects"`
+	Repositories      int `json:"repositories"`
+	Skipped           int `json:"skipped"`
+	Tags              int `json:"tags"`
+	Deleted           int `json:"deleted"`
+	Errors            int `json:"errors"`
+}
+
+func NewReport() *Report {
+	return &Report{
+		StartedAt:         time.Now().UTC(),
+		RepositoryConfigs: []*RepositoryConfig{},
+		Errors:            []string{},
+	}
+}
+
+// AddRepositoryConfig adds a repository config targeting project and/or group to the report
+func (r *Report) AddRepositoryConfig(project string, group string) *RepositoryConfig {
+	repositoryConfig := &RepositoryConfig{
+		Project:  project,
+		Group:    group,
+		Projects: []*Project{},
+	}
+	r.RepositoryConfigs = append(r.RepositoryConfigs, repositoryConfig)
+
+	return repositoryConfig
+}
+
+// AddError adds a run level error to the report
+func (r *Report) AddError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// Finish records the finish time of the run and calculates totals
+func (r *Report) Finish() {
+	r.FinishedAt = time.Now().UTC()
+
+	totals := Totals{
+		RepositoryConfigs: len(r.RepositoryConfigs),
+		Errors:            len(r.Errors),
+	}
+	for _, repositoryConfig := range r.RepositoryConfigs {
+		if len(repositoryConfig.Error) > 0 {
+			totals.Errors++
+		}
+		totals.Projects += len(repositoryConfig.Projects)
+		for _, project := range repositoryConfig.Projects {
+			totals.Repositories += len(project.Repositories)
+			for _, repository := range project.Repositories {
+				if len(repository.Skipped) > 0 {
+					totals.Skipped++
+				}
+				if len(repository.Error) > 0 {
+					totals.Errors++
+				}
+				totals.Tags += repository.Tags
+				totals.Deleted += len(repository.Deleted)
+			}
+		}
+	}
+	r.Totals = totals
+}
+
+// Write writes report as JSON to w
+func (r *Report) Write(w io.Writer) error {
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal report: %w", err)
+	}
+
+	_, err = w.Write(append(bytes, '\n'))
+	if err != nil {
+		return fmt.Errorf("Failed to write report: %w", err)
+	}
+
+	return nil
+}
+
+// WriteFile writes report as JSON to file at path
+func (r *Report) WriteFile(path string) error {
+	bytes, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Failed to marshal report: %w", err)
+	}
+
+	err = ioutil.WriteFile(path, bytes, 0644)
+	if err != nil {
+		return fmt.Errorf("Failed to write report file: %w", err)
+	}
+
+	return nil
+}
+
+// AddProject adds a project with ID id to the repository config
+func (c *RepositoryConfig) AddProject(id int) *Project {
+	project := &Project{
+		ID:           id,
+		Repositories: []*Repository{},
+	}
+	c.Projects = append(c.Projects, project)
+
+	return project
+}
+
+// AddRepository adds a repository with ID id and path to the project
+func (p *Project) AddRepository(id int, path string) *Repository {
+	repository := &Repository{
+		ID:       id,
+		Path:     path,
+		Policies: []*Policy{},
+		Deleted:  []string{},
+	}
+	p.Repositories = append(p.Repositories, repository)
+
+	return repository
+}
+
+// AddPolicy adds a policy with given name to the repository
+func (r *Repository) AddPolicy(name string) *Policy {
+	policy := &Policy{
+		Name: name,
+		Tags: []*TagDecision{},
+	}
+	r.Policies = append(r.Policies, policy)
+
+	return policy
+}
+
+// AddTag adds the decision made by the policy for a tag
+func (p *Policy) AddTag(tag string, digest string, action string, stage string) {
+	p.Tags = append(p.Tags, &TagDecision{
+		Tag:    tag,
+		Digest: digest,
+		Action: action,
+		Stage:  stage,
+	})
+}
@@ -0,0 +1,64 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReport_Finish(t *testing.T) {
+	t.Run("PopulatedReport_CalculatesTotals", func(t *testing.T) {
+		r := NewReport()
+		r.AddError(errors.New("test error"))
+
+		project := r.AddRepositoryConfig("1", "").AddProject(1)
+		repository := project.AddRepository(100, "group10/project1/app")
+		repository.Tags = 3
+		repository.Deleted = []string{"test1", "test2"}
+		skipped := project.AddRepository(101, "group10/project1/other")
+		skipped.Tags = 2
+		skipped.Skipped = "test limit exceeded"
+
+		r.AddRepositoryConfig("", "10").Error = "test config error"
+
+		r.Finish()
+
+		assert.Equal(t, Totals{
+			RepositoryConfigs: 2,
+			Projects:          1,
+			Repositories:      2,
+			Skipped:           1,
+			Tags:              5,
+			Deleted:           2,
+			Errors:            2,
+		}, r.Totals)
+		assert.False(t, r.FinishedAt.IsZero())
+	})
+}
+
+func TestReport_Write(t *testing.T) {
+	t.Run("PolicyDecisions_WritesJSON", func(t *testing.T) {
+		r := NewReport()
+		policy := r.AddRepositoryConfig("1", "").AddProject(1).AddRepository(100, "group10/project1/app").AddPolicy("keep2")
+		policy.AddTag("test1", "sha256:test1", ActionDelete, "KeepFilter")
+		policy.AddTag("test2", "sha256:test2", ActionKeep, "KeepFilter")
+
+		buf := &bytes.Buffer{}
+		err := r.Write(buf)
+
+		assert.Nil(t, err)
+
+		written := &Report{}
+		err = json.Unmarshal(buf.Bytes(), written)
+		assert.Nil(t, err)
+		assert.Equal(t, &TagDecision{
+			Tag:    "test2",
+			Digest: "sha256:test2",
+			Action: ActionKeep,
+			Stage:  "KeepFilter",
+		}, written.RepositoryConfigs[0].Projects[0].Repositories[0].Policies[0].Tags[1])
+	})
+}
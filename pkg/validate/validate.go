@@ -0,0 +1,165 @@
+package validate
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/filter"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/plan"
+)
+
+// Config validates cfg without making any API calls, returning all validation errors found
+func Config(cfg *config.Config) []error {
+	var errs []error
+
+	errs = append(errs, validateLimits("config", cfg.MaxDeletions, cfg.MaxDeletionPercent)...)
+	if cfg.MaxRunDeletions < 0 {
+		errs = append(errs, fmt.Errorf("config: max_run_deletions cannot be negative"))
+	}
+
+	policyNames := make(map[string]bool)
+	for i, policyCfg := range cfg.Policies {
+		scope := fmt.Sprintf("policies[%d]", i)
+		if len(policyCfg.Name) == 0 {
+			errs = append(errs, fmt.Errorf("%s: name must be specified", scope))
+		} else {
+			scope = fmt.Sprintf("policy %s", policyCfg.Name)
+			if policyNames[policyCfg.Name] {
+				errs = append(errs, fmt.Errorf("%s: policy is defined more than once", scope))
+			}
+			policyNames[policyCfg.Name] = true
+		}
+
+		errs = append(errs, validateFilter(scope, policyCfg.Filter)...)
+		errs = append(errs, validateLimits(scope, policyCfg.MaxDeletions, policyCfg.MaxDeletionPercent)...)
+	}
+
+	for i, repositoryCfg := range cfg.Repositories {
+		scope := fmt.Sprintf("repositories[%d]", i)
+
+		if len(repositoryCfg.Project) > 0 && len(repositoryCfg.Group) > 0 {
+			errs = append(errs, fmt.Errorf("%s: project and group cannot both be specified", scope))
+		}
+		if len(repositoryCfg.Policies) == 0 {
+			errs = append(errs, fmt.Errorf("%s: at least one policy must be specified", scope))
+		}
+		for _, policyName := range repositoryCfg.Policies {
+			if !policyNames[policyName] {
+				errs = append(errs, fmt.Errorf("%s: policy %s does not exist", scope, policyName))
+			}
+		}
+		switch repositoryCfg.PolicyMode {
+		case "", plan.MergeAny, plan.MergeAll:
+		default:
+			errs = append(errs, fmt.Errorf("%s: unsupported policy_mode %s", scope, repositoryCfg.PolicyMode))
+		}
+
+		errs = append(errs, validateGlobs(scope, "environments", repositoryCfg.Environments)...)
+		errs = append(errs, validateGlobs(scope, "scan_files", repositoryCfg.ScanFiles)...)
+
+		errs = append(errs, validateLimits(scope, repositoryCfg.MaxDeletions, repositoryCfg.MaxDeletionPercent)...)
+	}
+
+	return errs
+}
+
+func validateFilter(scope string, filterCfg config.FilterConfig) []error {
+	var errs []error
+
+	errs = append(errs, validateRegexp(scope, "include", filterCfg.Include)...)
+	errs = append(errs, validateRegexp(scope, "exclude", filterCfg.Exclude)...)
+	errs = append(errs, validateCaptureRegexp(scope, "keep_per_group", filterCfg.KeepPerGroup)...)
+	errs = append(errs, validateCaptureRegexp(scope, "deleted_branch pattern", filterCfg.DeletedBranch.Pattern)...)
+	errs = append(errs, validateCaptureRegexp(scope, "merge_request pattern", filterCfg.MergeRequest.Pattern)...)
+
+	if filterCfg.Keep < 0 {
+		errs = append(errs, fmt.Errorf("%s: keep cannot be negative", scope))
+	}
+	if filterCfg.Age < 0 {
+		errs = append(errs, fmt.Errorf("%s: age cannot be negative", scope))
+	}
+	if filterCfg.MinAge < 0 {
+		errs = append(errs, fmt.Errorf("%s: min_age cannot be negative", scope))
+	}
+	if filterCfg.KeepPatchesPerMinor < 0 {
+		errs = append(errs, fmt.Errorf("%s: keep_patches_per_minor cannot be negative", scope))
+	}
+	if filterCfg.KeepDaily < 0 || filterCfg.KeepWeekly < 0 || filterCfg.KeepMonthly < 0 {
+		errs = append(errs, fmt.Errorf("%s: keep_daily, keep_weekly and keep_monthly cannot be negative", scope))
+	}
+	if filterCfg.DeletedBranch.GracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("%s: deleted_branch grace_period cannot be negative", scope))
+	}
+	if filterCfg.MergeRequest.GracePeriod < 0 {
+		errs = append(errs, fmt.Errorf("%s: merge_request grace_period cannot be negative", scope))
+	}
+
+	if len(filterCfg.GitTagTemplate) > 0 && !strings.Contains(filterCfg.GitTagTemplate, filter.GitTagPlaceholder) {
+		errs = append(errs, fmt.Errorf("%s: git_tag_template must contain %s", scope, filter.GitTagPlaceholder))
+	}
+
+	switch filterCfg.Order {
+	case "", filter.OrderCreated, filter.OrderSemver:
+	default:
+		errs = append(errs, fmt.Errorf("%s: unsupported order %s", scope, filterCfg.Order))
+	}
+
+	return errs
+}
+
+func validateRegexp(scope string, key string, expr string) []error {
+	if len(expr) == 0 {
+		return nil
+	}
+
+	_, err := regexp.Compile(expr)
+	if err != nil {
+		return []error{fmt.Errorf("%s: invalid %s regex: %s", scope, key, err)}
+	}
+
+	return nil
+}
+
+// validateCaptureRegexp validates expr is a valid regex containing at least one capture group
+func validateCaptureRegexp(scope string, key string, expr string) []error {
+	if len(expr) == 0 {
+		return nil
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return []error{fmt.Errorf("%s: invalid %s regex: %s", scope, key, err)}
+	}
+	if re.NumSubexp() < 1 {
+		return []error{fmt.Errorf("%s: %s regex must contain a capture group", scope, key)}
+	}
+
+	return nil
+}
+
+func validateGlobs(scope string, key string, patterns []string) []error {
+	var errs []error
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid %s pattern %s: %s", scope, key, pattern, err))
+		}
+	}
+
+	return errs
+}
+
+func validateLimits(scope string, maxDeletions int, maxDeletionPercent float64) []error {
+	var errs []error
+
+	if maxDeletions < 0 {
+		errs = append(errs, fmt.Errorf("%s: max_deletions cannot be negative", scope))
+	}
+	if maxDeletionPercent < 0 || maxDeletionPercent > 100 {
+		errs = append(errs, fmt.Errorf("%s: max_deletion_percent must be between 0 and 100", scope))
+	}
+
+	return errs
+}
@@ -0,0 +1,156 @@
+package validate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/ukfast/gitlab-registry-cleanup/pkg/config"
+)
+
+func validConfig() *config.Config {
+	return &config.Config{
+		Policies: []config.PolicyConfig{
+			{
+				Name: "testpolicy",
+				Filter: config.FilterConfig{
+					Include: ".*",
+					Exclude: "^v.+",
+					Keep:    5,
+					Age:     config.Days(30),
+				},
+			},
+		},
+		Repositories: []config.RepositoryConfig{
+			{
+				Project:  "123",
+				Policies: []string{"testpolicy"},
+			},
+		},
+	}
+}
+
+func TestConfig(t *testing.T) {
+	t.Run("ValidConfig_ReturnsNoErrors", func(t *testing.T) {
+		errs := Config(validConfig())
+
+		assert.Len(t, errs, 0)
+	})
+
+	t.Run("MissingPolicy_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Repositories[0].Policies = []string{"missingpolicy"}
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("InvalidRegex_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.Include = "("
+		cfg.Policies[0].Filter.Exclude = "("
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("NegativeKeepAndAges_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.Keep = -1
+		cfg.Policies[0].Filter.Age = -1
+		cfg.Policies[0].Filter.MinAge = -1
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 3)
+	})
+
+	t.Run("ProjectAndGroup_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Repositories[0].Group = "456"
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("UnsupportedOrderAndPolicyMode_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.Order = "invalid"
+		cfg.Repositories[0].PolicyMode = "invalid"
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("KeepPerGroupWithoutCaptureGroup_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.KeepPerGroup = "^.+-[0-9a-f]{8}$"
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("DeletedBranchInvalid_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.DeletedBranch = config.DeletedBranchConfig{
+			Pattern:     "^.+$",
+			GracePeriod: -1,
+		}
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("MergeRequestInvalid_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.MergeRequest = config.MergeRequestConfig{
+			Pattern:     "^mr-[0-9]+$",
+			GracePeriod: -1,
+		}
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 2)
+	})
+
+	t.Run("InvalidEnvironmentPattern_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Repositories[0].Environments = []string{"production/*", "staging/["}
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("GitTagTemplateWithoutPlaceholder_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies[0].Filter.GitTagTemplate = "release-tag"
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("InvalidScanFilesPattern_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Repositories[0].ScanFiles = []string{"docker-compose.yml", "values[.yaml"}
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+
+	t.Run("DuplicatePolicy_ReturnsError", func(t *testing.T) {
+		cfg := validConfig()
+		cfg.Policies = append(cfg.Policies, cfg.Policies[0])
+
+		errs := Config(cfg)
+
+		assert.Len(t, errs, 1)
+	})
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	t.Run("NoErrors_ExecutesAll", func(t *testing.T) {
+		results := make([]int, 100)
+
+		err := Run(8, len(results), func(i int) error {
+			results[i] = i * 2
+			return nil
+		})
+
+		assert.Nil(t, err)
+		for i, result := range results {
+			assert.Equal(t, i*2, result)
+		}
+	})
+
+	t.Run("Concurrency_DoesNotExceedLimit", func(t *testing.T) {
+		var mu sync.Mutex
+		running := 0
+		maxRunning := 0
+
+		err := Run(3, 50, func(i int) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.LessOrEqual(t, maxRunning, 3)
+	})
+
+	t.Run("Error_ReturnsErrors", func(t *testing.T) {
+		err := Run(1, 10, func(i int) error {
+			if i == 2 {
+				return errors.New("test error")
+			}
+			return nil
+		})
+
+		assert.NotNil(t, err)
+		assert.IsType(t, Errors{}, err)
+		assert.Len(t, err.(Errors), 1)
+		assert.Equal(t, "test error", err.Error())
+	})
+
+	t.Run("ZeroConcurrency_ExecutesSequentially", func(t *testing.T) {
+		count := 0
+
+		err := Run(0, 5, func(i int) error {
+			count++
+			return nil
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 5, count)
+	})
+}
+
+func TestErrors_Error(t *testing.T) {
+	err := Errors{errors.New("error1"), errors.New("error2")}
+
+	assert.Equal(t, "2 errors occurred: error1; error2", err.Error())
+}